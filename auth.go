@@ -0,0 +1,174 @@
+package workflow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// Permission is what a client of the workflow handlers is allowed to do.
+type Permission int
+
+const (
+	PermissionNone    Permission = iota // Nothing, requests are refused
+	PermissionRead                      // Follow the status and events of the workflow
+	PermissionControl                   // Also start, continue, abort, reset and answer prompts
+)
+
+// String returns the name of p, as used in signed URLs.
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionControl:
+		return "control"
+	}
+	return "none"
+}
+
+// An Authorizer decides what the client making a request to the websocket,
+// events and control handlers of a workflow can do. It is set with
+// [Workflow.SetAuthorizer].
+type Authorizer interface {
+	Authorize(r *http.Request) Permission
+}
+
+// AuthorizerFunc is an adapter to use ordinary functions as authorizers, for
+// example to check a session cookie.
+type AuthorizerFunc func(r *http.Request) Permission
+
+// Authorize calls f(r).
+func (f AuthorizerFunc) Authorize(r *http.Request) Permission {
+	return f(r)
+}
+
+// BearerToken returns an Authorizer granting read permission to requests
+// carrying the read token, and control permission to the ones carrying the
+// control token, either in an `Authorization: Bearer` header or in the
+// access_token query parameter for clients that can't set headers, like
+// browsers opening websockets. An empty token grants nothing.
+func BearerToken(read, control string) Authorizer {
+	return AuthorizerFunc(func(r *http.Request) Permission {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("access_token")
+		}
+
+		equal := func(a, b string) bool {
+			return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+		}
+		switch {
+		case token == "":
+			return PermissionNone
+		case equal(token, control):
+			return PermissionControl
+		case equal(token, read):
+			return PermissionRead
+		}
+		return PermissionNone
+	})
+}
+
+// SignedURLs returns an Authorizer granting the permission of URLs signed
+// with [SignURL] using key, until they expire.
+func SignedURLs(key []byte) Authorizer {
+	return AuthorizerFunc(func(r *http.Request) Permission {
+		query := r.URL.Query()
+		perm, expires, sig := query.Get("perm"), query.Get("expires"), query.Get("sig")
+
+		t, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > t {
+			return PermissionNone
+		}
+
+		want, err := hex.DecodeString(sig)
+		if err != nil || !hmac.Equal(want, signature(key, requestPath(r), perm, expires)) {
+			return PermissionNone
+		}
+
+		switch perm {
+		case PermissionRead.String():
+			return PermissionRead
+		case PermissionControl.String():
+			return PermissionControl
+		}
+		return PermissionNone
+	})
+}
+
+// SignURL returns rawURL with query parameters granting permission p on its
+// path until expires, for authorizers returned by [SignedURLs] with the same
+// key. Other query parameters are not signed, and can be added later.
+func SignURL(key []byte, rawURL string, p Permission, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("perm", p.String())
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", hex.EncodeToString(signature(key, u.EscapedPath(), p.String(), query.Get("expires"))))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// signature returns the signature of a path with permission perm until
+// expires.
+func signature(key []byte, path, perm, expires string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "\n" + perm + "\n" + expires))
+	return mac.Sum(nil)
+}
+
+// requestPath returns the path requested by the client of r, before any
+// [http.StripPrefix].
+func requestPath(r *http.Request) string {
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		return u.EscapedPath()
+	}
+	return r.URL.EscapedPath()
+}
+
+// SetAuthorizer sets the authorizer of the websocket handler returned by
+// [New], and of the handlers returned by [Workflow.EventsHandler] and
+// [Workflow.ControlHandler]. Requests without permission are refused with
+// 401 Unauthorized, actions and websocket commands needing the control
+// permission with 403 Forbidden or [WorkflowErrorForbidden].
+//
+// Without authorizer, anyone can follow the workflow and use the control
+// handler, but websocket commands are refused.
+func (w *Workflow) SetAuthorizer(a Authorizer) {
+	w.Lock()
+	defer w.Unlock()
+	w.authorizer = a
+}
+
+// SetAcceptOptions sets the options used to accept websocket connections, for
+// example to allow cross-origin connections with OriginPatterns. By default
+// only same-origin connections are accepted.
+func (w *Workflow) SetAcceptOptions(opts *websocket.AcceptOptions) {
+	w.Lock()
+	defer w.Unlock()
+	w.acceptOptions = opts
+}
+
+// authorize returns the permission of the client making r, or permission if
+// no authorizer is set.
+func (w *Workflow) authorize(r *http.Request, permission Permission) Permission {
+	w.Lock()
+	a := w.authorizer
+	w.Unlock()
+	if a == nil {
+		return permission
+	}
+	return a.Authorize(r)
+}
@@ -0,0 +1,186 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestBearerToken(t *testing.T) {
+	a := BearerToken("reader", "controller")
+
+	tests := []struct {
+		header string
+		query  string
+		want   Permission
+	}{
+		{"", "", PermissionNone},
+		{"Bearer reader", "", PermissionRead},
+		{"Bearer controller", "", PermissionControl},
+		{"Bearer other", "", PermissionNone},
+		{"Basic reader", "", PermissionNone},
+		{"", "access_token=controller", PermissionControl},
+		{"", "access_token=", PermissionNone},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/wf?"+test.query, nil)
+		if test.header != "" {
+			r.Header.Set("Authorization", test.header)
+		}
+		if got := a.Authorize(r); got != test.want {
+			t.Errorf("%q %q: want %s, got %s", test.header, test.query, test.want, got)
+		}
+	}
+
+	// Empty tokens grant nothing
+	r := httptest.NewRequest(http.MethodGet, "/wf", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	if got := BearerToken("", "").Authorize(r); got != PermissionNone {
+		t.Errorf("want %s, got %s", PermissionNone, got)
+	}
+}
+
+func TestSignedURLs(t *testing.T) {
+	key := []byte("secret")
+	a := SignedURLs(key)
+
+	sign := func(u string, p Permission, expires time.Time) string {
+		t.Helper()
+		s, err := SignURL(key, u, p, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		url  string
+		want Permission
+	}{
+		{"read", sign("/api/wf", PermissionRead, later), PermissionRead},
+		{"control", sign("http://localhost:8080/api/wf", PermissionControl, later), PermissionControl},
+		{"extra parameters", sign("/api/wf?since=3", PermissionRead, later) + "&x=1", PermissionRead},
+		{"expired", sign("/api/wf", PermissionControl, time.Now().Add(-time.Minute)), PermissionNone},
+		{"other path", strings.Replace(sign("/api/wf", PermissionRead, later), "/api/wf", "/api/other", 1), PermissionNone},
+		{"tampered", strings.Replace(sign("/api/wf", PermissionRead, later), "perm=read", "perm=control", 1), PermissionNone},
+		{"invalid signature", sign("/api/wf", PermissionRead, later) + "0", PermissionNone},
+		{"unsigned", "/api/wf", PermissionNone},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.url, nil)
+		if got := a.Authorize(r); got != test.want {
+			t.Errorf("%s: want %s, got %s", test.name, test.want, got)
+		}
+	}
+
+	// The signature covers the requested path, before prefixes are stripped
+	var got Permission
+	h := http.StripPrefix("/api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = a.Authorize(r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, sign("/api/wf", PermissionRead, later), nil))
+	if got != PermissionRead {
+		t.Errorf("want %s, got %s", PermissionRead, got)
+	}
+}
+
+// Test permissions on the workflow handlers
+func TestAuthorizer(t *testing.T) {
+	wf, fn, err := New("test_data/test-prompt.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.SetAuthorizer(BearerToken("reader", "controller"))
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/workflow/", wf.ControlHandler())
+	mux.Handle("/events", wf.EventsHandler())
+	mux.Handle("/wf", fn)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{http.MethodGet, "/api/workflow/status", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/workflow/status", "reader", http.StatusOK},
+		{http.MethodPost, "/api/workflow/abort", "reader", http.StatusForbidden},
+		{http.MethodPost, "/api/workflow/abort", "controller", http.StatusConflict},
+		{http.MethodGet, "/events", "", http.StatusUnauthorized},
+		{http.MethodGet, "/wf", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest(test.method, s.URL+test.path, nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.want {
+			t.Errorf("%s %s with %q: want %d, got %d", test.method, test.path, test.token, test.want, resp.StatusCode)
+		}
+	}
+
+	// Websocket commands need the control permission, the workflow not being
+	// finished is only checked once allowed
+	for token, want := range map[string]error{"reader": WorkflowErrorForbidden, "controller": WorkflowErrorNotFinished} {
+		send, read := make_commands_socket(t, withToken(fn, token))
+		send(Command{Id: "1", Command: "reset"})
+		if m := read(reply("1")); m.Type != EventNack || m.Error != want.Error() {
+			t.Errorf("%s: want %q, got %+v", token, want, m)
+		}
+	}
+}
+
+// withToken returns h with requests authorized by token.
+func withToken(h http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Test websocket accept options
+func TestAcceptOptions(t *testing.T) {
+	wf, fn, err := New("test_data/test-prompt.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(fn)
+	defer s.Close()
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	dial := func() error {
+		conn, _, err := websocket.Dial(context.Background(), u, &websocket.DialOptions{
+			HTTPHeader: http.Header{"Origin": {"https://ui.example.com"}},
+		})
+		if err == nil {
+			conn.CloseNow()
+		}
+		return err
+	}
+
+	// Cross-origin connections are refused by default
+	if err := dial(); err == nil {
+		t.Fatal("cross-origin connection accepted")
+	}
+
+	wf.SetAcceptOptions(&websocket.AcceptOptions{OriginPatterns: []string{"ui.example.com"}})
+	if err := dial(); err != nil {
+		t.Fatal(err)
+	}
+}
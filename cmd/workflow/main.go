@@ -0,0 +1,113 @@
+// Command workflow runs and checks workflow definition files.
+//
+// Usage:
+//
+//	workflow run [-f workflow.yaml] [-s status.json] [-var NAME=value]...
+//	workflow continue [-f workflow.yaml] [-s status.json] [-var NAME=value]...
+//	workflow status [-f workflow.yaml] [-s status.json] [-json]
+//	workflow abort [-f workflow.yaml] [-s status.json]
+//	workflow reset [-f workflow.yaml] [-s status.json]
+//	workflow validate FILE...
+//	workflow schema
+//
+// The run command starts the workflow defined in the -f file, rendering the
+// progress of groups and tasks when the output is a terminal. The status is
+// persisted in the -s file, by default the definition file name with a
+// .status.json extension, so that a workflow stopped by an `exits` task can be
+// resumed with continue. Inputs of the workflow are given with -var, and must
+// be given again to continue for required secret inputs.
+//
+// While a workflow runs, status prints its progress, and abort stops it,
+// like an interrupt signal would. The reset command discards the status of
+// an interrupted run so that it can be run again from the start.
+//
+// The run and continue commands exit with status 0 when the workflow
+// succeeded, 1 when a task failed, 3 when it was aborted and 4 when a timeout
+// expired. Invalid arguments, inputs or definitions exit with status 2, and
+// tasks exiting the program to be continued with status 128.
+//
+// The validate command checks the definition files and reports all their
+// problems with their position, exiting with a non-zero status if any is
+// found. It doesn't read or write status files, which makes it suitable for
+// pre-commit hooks.
+//
+// The schema command prints the JSON Schema of definition files, for editors
+// to provide completion and validation.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ybizeul/workflow"
+)
+
+// Exit codes
+const (
+	exitSuccess = 0
+	exitFailure = 1 // A task failed
+	exitUsage   = 2 // Invalid arguments or definition
+	exitAborted = 3
+	exitTimeout = 4
+)
+
+const usage = `usage: workflow <command> [arguments]
+
+Commands:
+  run               run a workflow
+  continue          continue a workflow stopped by an exits task
+  status            print the progress of a workflow
+  abort             abort a running workflow
+  reset             discard the status of an interrupted workflow
+  validate FILE...  check workflow definition files
+  schema            print the JSON Schema of workflow definition files
+
+Run 'workflow <command> -h' for the arguments of a command.
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "run", "continue", "status", "abort", "reset":
+		os.Exit(command(flag.Arg(0), args))
+	case "validate":
+		os.Exit(validate(args, os.Stderr))
+	case "schema":
+		_, _ = os.Stdout.Write(workflow.JSONSchema())
+	default:
+		fmt.Fprintf(os.Stderr, "workflow: unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+}
+
+// validate checks the definition files in args, writes problems to stderr
+// and returns the exit status.
+func validate(args []string, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, "usage: workflow validate FILE...\n")
+		return exitUsage
+	}
+
+	status := exitSuccess
+	for _, path := range args {
+		err := workflow.Validate(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			status = exitFailure
+		}
+	}
+	return status
+}
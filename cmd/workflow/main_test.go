@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		output string
+	}{
+		{"valid", []string{"../../test_data/test.yaml", "../../test_data/test-dag.yaml"}, 0, ""},
+		{"invalid", []string{"../../test_data/test.yaml", "../../test_data/test-invalid.yaml"}, 1, "../../test_data/test-invalid.yaml:7:9: invalid weight"},
+		{"usage", nil, 2, "usage:"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			status := validate(test.args, stderr)
+			if status != test.status {
+				t.Fatalf("want status %d, got %d: %s", test.status, status, stderr)
+			}
+			if !strings.Contains(stderr.String(), test.output) {
+				t.Fatalf("want output containing %q, got %q", test.output, stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ybizeul/workflow"
+)
+
+const (
+	barWidth     = 20
+	messageWidth = 50 // Longer messages would wrap and break the rendering
+)
+
+// progress renders the progress of a running workflow in a terminal, with the
+// output of tasks scrolling above the progress bars.
+type progress struct {
+	out   io.Writer
+	wf    *workflow.Workflow
+	lines int // Number of lines of the last rendering, to be erased
+
+	pipe   *os.File // Writing end of the capture pipe
+	output chan string
+}
+
+// newProgress returns a progress rendering wf. Rendering is disabled when
+// the standard output is not a terminal.
+func newProgress(wf *workflow.Workflow) *progress {
+	p := &progress{out: os.Stdout, wf: wf}
+	if !isTerminal(os.Stdout) {
+		p.out = nil
+	}
+	return p
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// start captures the output of tasks and logs, so they can be printed above
+// the progress bars.
+func (p *progress) start() {
+	if p.out == nil {
+		return
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		// Render without capturing
+		return
+	}
+	p.pipe = w
+	p.output = make(chan string)
+	p.wf.SetOutput(w)
+	log.SetOutput(w)
+
+	go func() {
+		defer close(p.output)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			p.output <- scanner.Text()
+		}
+	}()
+}
+
+// wait renders the progress until done returns the result of the workflow
+// run, and returns it.
+func (p *progress) wait(done <-chan error) error {
+	if p.out == nil {
+		return <-done
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-p.output:
+			if !ok {
+				p.output = nil
+				continue
+			}
+			// Progress messages are already rendered as bars
+			if !strings.HasPrefix(line, "progress:: ") {
+				p.print(line)
+			}
+		case <-ticker.C:
+			p.render()
+		case err := <-done:
+			p.stop()
+			p.render()
+			return err
+		}
+	}
+}
+
+// stop restores the output of tasks and logs, after printing the remaining
+// captured output. Tasks may have left background processes writing to it,
+// so it doesn't wait for more than a moment.
+func (p *progress) stop() {
+	if p.pipe == nil {
+		return
+	}
+	p.wf.SetOutput(nil)
+	log.SetOutput(os.Stderr)
+	_ = p.pipe.Close()
+
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case line, ok := <-p.output:
+			if !ok {
+				return
+			}
+			if !strings.HasPrefix(line, "progress:: ") {
+				p.print(line)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// print prints line above the progress bars.
+func (p *progress) print(line string) {
+	b := &strings.Builder{}
+	p.erase(b)
+	b.WriteString(line + "\n")
+	p.lines = renderStatus(b, snapshot(p.wf))
+	_, _ = io.WriteString(p.out, b.String())
+}
+
+// render redraws the progress bars.
+func (p *progress) render() {
+	b := &strings.Builder{}
+	p.erase(b)
+	p.lines = renderStatus(b, snapshot(p.wf))
+	_, _ = io.WriteString(p.out, b.String())
+}
+
+// erase moves the cursor up to the beginning of the last rendering and
+// clears the rest of the screen.
+func (p *progress) erase(b *strings.Builder) {
+	if p.lines > 0 {
+		fmt.Fprintf(b, "\x1b[%dA\x1b[J", p.lines)
+	}
+}
+
+// snapshot returns a copy of the status of wf, safe to read while the
+// workflow runs.
+func snapshot(wf *workflow.Workflow) *workflow.Status {
+	wf.Lock()
+	b, err := json.Marshal(&wf.Status)
+	wf.Unlock()
+
+	result := &workflow.Status{}
+	if err == nil {
+		_ = json.Unmarshal(b, result)
+	}
+	return result
+}
+
+// renderStatus writes progress bars for the groups and tasks of status to w,
+// and returns the number of lines written.
+func renderStatus(w io.Writer, status *workflow.Status) int {
+	groups := [][]*workflow.Group{status.Groups, status.OnSuccess, status.OnFailure, status.Finally}
+
+	// Align bars on the longest id
+	width := 0
+	for _, list := range groups {
+		for _, group := range list {
+			width = max(width, len(group.Id))
+			for _, task := range group.Tasks {
+				width = max(width, len(task.Id)+2)
+			}
+		}
+	}
+
+	lines := 0
+	writeLine := func(format string, args ...any) {
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf(format, args...), " "))
+		lines++
+	}
+
+	for i, list := range groups {
+		for _, group := range list {
+			// Hooks are only shown once they run
+			if i > 0 && !group.Started {
+				continue
+			}
+			writeLine("%-*s %s %3.0f%%  %s", width, group.Id, bar(group.Percent/100), group.Percent, groupState(status, group))
+			for _, task := range group.Tasks {
+				writeLine("  %-*s %s %3.0f%%  %s", width-2, task.Id, bar(taskPercent(task)), taskPercent(task)*100, taskState(status, group, task))
+			}
+		}
+	}
+
+	writeLine("%s", workflowState(status))
+	return lines
+}
+
+// bar returns a progress bar for a ratio between 0 and 1.
+func bar(ratio float64) string {
+	filled := int(min(max(ratio, 0), 1) * barWidth)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", barWidth-filled) + "]"
+}
+
+// taskPercent returns the progress of task between 0 and 1.
+func taskPercent(task *workflow.Task) float64 {
+	if task.Finished {
+		return 1
+	}
+	return task.Percent
+}
+
+func workflowState(status *workflow.Status) string {
+	switch {
+	case status.Finished:
+		result := fmt.Sprintf("%d%% %s", status.Percent, status.Outcome)
+		if status.Error != "" {
+			result += ": " + message(status.Error)
+		}
+		return result
+	case status.Started:
+		return fmt.Sprintf("%d%% running", status.Percent)
+	}
+	return "not started"
+}
+
+func groupState(status *workflow.Status, group *workflow.Group) string {
+	switch {
+	case group.Skip:
+		return "skipped"
+	case group.Blocked:
+		return "blocked"
+	case group.TimedOut:
+		return "timed out"
+	case group.Finished && group.Warning:
+		return "warning: " + message(group.Error)
+	case group.Finished:
+		return "done"
+	case slices.Contains(status.CurrentGroups, group.Id):
+		return "running"
+	case group.Error != "":
+		return "failed: " + message(group.Error)
+	}
+	return ""
+}
+
+func taskState(status *workflow.Status, group *workflow.Group, task *workflow.Task) string {
+	switch {
+	case task.Skip:
+		return "skipped"
+	case task.Blocked:
+		return "blocked"
+	case task.TimedOut:
+		return "timed out"
+	case task.Finished && task.Warning:
+		return "warning: " + message(task.Error)
+	case task.Finished:
+		return "done"
+	case task.Started && slices.Contains(status.CurrentGroups, group.Id) && slices.Contains(status.CurrentTasks, task.Id):
+		if task.Attempt > 1 {
+			return fmt.Sprintf("running (attempt %d) %s", task.Attempt, message(task.LastMessage))
+		}
+		return "running " + message(task.LastMessage)
+	case task.Error != "":
+		return "failed: " + message(task.Error)
+	}
+	return ""
+}
+
+// message returns s on a single line, truncated to messageWidth.
+func message(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > messageWidth {
+		return string(r[:messageWidth-1]) + "…"
+	}
+	return s
+}
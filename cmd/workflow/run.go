@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ybizeul/workflow"
+)
+
+// command runs the command name operating on a workflow, with arguments args,
+// and returns the exit status.
+func command(name string, args []string) int {
+	flags := flag.NewFlagSet("workflow "+name, flag.ContinueOnError)
+	definitionPath := flags.String("f", "workflow.yaml", "workflow definition `file`")
+	statusPath := flags.String("s", "", "status `file` (default definition file with a .status.json extension)")
+	jsonOutput := false
+	if name == "status" {
+		flags.BoolVar(&jsonOutput, "json", false, "print the status as JSON")
+	}
+	inputs := map[string]string{}
+	if name == "run" || name == "continue" {
+		flags.Func("var", "input `NAME=value` of the workflow, can be repeated", func(s string) error {
+			k, v, ok := strings.Cut(s, "=")
+			if !ok {
+				return errors.New("expected NAME=value")
+			}
+			inputs[k] = v
+			return nil
+		})
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return exitUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "workflow %s: unexpected argument %q\n", name, flags.Arg(0))
+		return exitUsage
+	}
+	if *statusPath == "" {
+		*statusPath = defaultStatusPath(*definitionPath)
+	}
+
+	switch name {
+	case "run":
+		return run(*definitionPath, *statusPath, false, inputs)
+	case "continue":
+		return run(*definitionPath, *statusPath, true, inputs)
+	case "status":
+		return status(*definitionPath, *statusPath, jsonOutput)
+	case "abort":
+		return abort(*statusPath)
+	case "reset":
+		return reset(*statusPath)
+	}
+	return exitUsage
+}
+
+// defaultStatusPath returns the status file path for the definition file at
+// definitionPath.
+func defaultStatusPath(definitionPath string) string {
+	return strings.TrimSuffix(definitionPath, filepath.Ext(definitionPath)) + ".status.json"
+}
+
+// pidPath returns the path of the file holding the process id of the program
+// running the workflow with status file at statusPath.
+func pidPath(statusPath string) string {
+	return statusPath + ".pid"
+}
+
+// lockPid creates the pid file of the workflow with status file at
+// statusPath, and locks it until the returned file is closed. The lock is
+// released by the system however the program ends, so a pid file left behind
+// by an `exits` task or a reboot is not mistaken for a running workflow. It
+// returns an error wrapping syscall.EWOULDBLOCK if the workflow is running.
+func lockPid(statusPath string) (*os.File, error) {
+	f, err := os.OpenFile(pidPath(statusPath), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to lock %s: %w", f.Name(), err)
+	}
+	return f, nil
+}
+
+// running returns the process id of the program running the workflow with
+// status file at statusPath, if any.
+func running(statusPath string) (int, bool) {
+	f, err := os.Open(pidPath(statusPath))
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	// The program running the workflow holds a lock on the pid file, the
+	// process id it contains may have been reused otherwise
+	if syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) == nil {
+		return 0, false
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	return pid, true
+}
+
+// run runs the workflow with inputs, continuing a previous run if resume is
+// true, and returns the exit status mapping its outcome.
+func run(definitionPath, statusPath string, resume bool, inputs map[string]string) int {
+	pidFile, err := lockPid(statusPath)
+	switch {
+	case errors.Is(err, syscall.EWOULDBLOCK):
+		if pid, ok := running(statusPath); ok {
+			fmt.Fprintf(os.Stderr, "workflow: already running with pid %d\n", pid)
+		} else {
+			fmt.Fprintln(os.Stderr, "workflow: already running")
+		}
+		return exitUsage
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	defer func() {
+		os.Remove(pidFile.Name())
+		pidFile.Close()
+	}()
+
+	_, err = os.Stat(statusPath)
+	switch {
+	case resume && err != nil:
+		fmt.Fprintf(os.Stderr, "workflow: nothing to continue, %s not found\n", statusPath)
+		return exitUsage
+	case !resume && err == nil:
+		fmt.Fprintf(os.Stderr, "workflow: %s exists from a previous run, use continue or reset\n", statusPath)
+		return exitUsage
+	}
+
+	wf, _, err := workflow.New(definitionPath, statusPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	// Interrupting the program, or running abort once the pid file is
+	// written, aborts the workflow
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for range signals {
+			wf.Abort()
+		}
+	}()
+
+	_, err = pidFile.WriteString(strconv.Itoa(os.Getpid()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	p := newProgress(wf)
+	p.start()
+
+	done := make(chan error, 1)
+	go func() {
+		if resume {
+			done <- wf.ContinueWithInputs(inputs)
+		} else {
+			done <- wf.StartWithInputs(inputs)
+		}
+	}()
+
+	err = p.wait(done)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	return exitCode(snapshot(wf), err)
+}
+
+// exitCode returns the exit status for a workflow run with status, that
+// returned err.
+func exitCode(status *workflow.Status, err error) int {
+	switch status.Outcome {
+	case workflow.OutcomeSuccess, workflow.OutcomeWarning:
+		return exitSuccess
+	case workflow.OutcomeAborted:
+		return exitAborted
+	case workflow.OutcomeTimeout:
+		return exitTimeout
+	}
+	if errors.Is(err, workflow.WorkflowErrorUnknownTaskType) || errors.Is(err, workflow.WorkflowErrorInvalidInput) {
+		return exitUsage
+	}
+	return exitFailure
+}
+
+// status prints the status of the workflow, running or not.
+func status(definitionPath, statusPath string, jsonOutput bool) int {
+	wf, _, err := workflow.New(definitionPath, statusPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if jsonOutput {
+		b, err := json.MarshalIndent(&wf.Status, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		fmt.Println(string(b))
+		return exitSuccess
+	}
+
+	renderStatus(os.Stdout, &wf.Status)
+	if pid, ok := running(statusPath); ok {
+		fmt.Printf("running with pid %d\n", pid)
+	}
+	return exitSuccess
+}
+
+// abort aborts the workflow running in another process.
+func abort(statusPath string) int {
+	pid, ok := running(statusPath)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "workflow: not running")
+		return exitFailure
+	}
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// reset discards the status of an interrupted workflow.
+func reset(statusPath string) int {
+	if pid, ok := running(statusPath); ok {
+		fmt.Fprintf(os.Stderr, "workflow: running with pid %d, abort it first\n", pid)
+		return exitUsage
+	}
+	err := os.Remove(statusPath)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	_ = os.Remove(pidPath(statusPath))
+	return exitSuccess
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ybizeul/workflow"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		status     int
+	}{
+		{"success", "../../test_data/test-allow-failure.yaml", exitSuccess},
+		{"failure", "../../test_data/test-hooks.yaml", exitFailure},
+		{"timeout", "../../test_data/test-timeout.yaml", exitTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statusPath := path.Join(t.TempDir(), "status.json")
+			if status := run(test.definition, statusPath, false, nil); status != test.status {
+				t.Fatalf("want status %d, got %d", test.status, status)
+			}
+			if _, err := os.Stat(pidPath(statusPath)); !os.IsNotExist(err) {
+				t.Fatalf("pid file should be removed")
+			}
+		})
+	}
+}
+
+// Test aborting a running workflow with the abort command
+func TestRunAborted(t *testing.T) {
+	statusPath := path.Join(t.TempDir(), "status.json")
+	done := make(chan int, 1)
+	go func() {
+		done <- run("../../test_data/test-control.yaml", statusPath, false, nil)
+	}()
+
+	// Wait for the workflow to write its status once started
+	for {
+		_, ok := running(statusPath)
+		if _, err := os.Stat(statusPath); ok && err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status := abort(statusPath); status != exitSuccess {
+		t.Fatalf("abort: want status %d, got %d", exitSuccess, status)
+	}
+	if status := <-done; status != exitAborted {
+		t.Fatalf("want status %d, got %d", exitAborted, status)
+	}
+}
+
+// Test that the pid file left behind by an exits task doesn't make the
+// workflow look like it is still running, even if its pid is reused
+func TestRunExits(t *testing.T) {
+	definition := "../../test_data/test-exits.yaml"
+	if statusPath := os.Getenv("WORKFLOW_TEST_STATUS"); statusPath != "" {
+		os.Exit(run(definition, statusPath, false, nil))
+	}
+
+	statusPath := path.Join(t.TempDir(), "status.json")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRunExits$")
+	cmd.Env = append(os.Environ(), "WORKFLOW_TEST_STATUS="+statusPath)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 128 {
+		t.Fatalf("want exit code 128, got %v", err)
+	}
+
+	err = os.WriteFile(pidPath(statusPath), []byte(strconv.Itoa(os.Getpid())), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, ok := running(statusPath); ok {
+		t.Fatalf("workflow should not be running, got pid %d", pid)
+	}
+	if status := abort(statusPath); status != exitFailure {
+		t.Fatalf("abort: want status %d, got %d", exitFailure, status)
+	}
+	if status := run(definition, statusPath, true, nil); status != exitSuccess {
+		t.Fatalf("continue: want status %d, got %d", exitSuccess, status)
+	}
+}
+
+// Test that invalid inputs are reported as usage errors
+func TestRunInputs(t *testing.T) {
+	definition := "../../test_data/test-inputs.yaml"
+	if status := run(definition, path.Join(t.TempDir(), "status.json"), false, nil); status != exitUsage {
+		t.Fatalf("want status %d, got %d", exitUsage, status)
+	}
+	if status := command("run", []string{"-f", definition, "-s", path.Join(t.TempDir(), "status.json"), "-var", "ENVIRONMENT=staging"}); status != exitSuccess {
+		t.Fatalf("want status %d, got %d", exitSuccess, status)
+	}
+}
+
+// Test that run and continue check for a previous run
+func TestRunStatusFile(t *testing.T) {
+	statusPath := path.Join(t.TempDir(), "status.json")
+
+	if status := run("../../test_data/test.yaml", statusPath, true, nil); status != exitUsage {
+		t.Fatalf("continue without status file: want status %d, got %d", exitUsage, status)
+	}
+
+	err := os.WriteFile(statusPath, []byte("{}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := run("../../test_data/test.yaml", statusPath, false, nil); status != exitUsage {
+		t.Fatalf("run with status file: want status %d, got %d", exitUsage, status)
+	}
+
+	if status := reset(statusPath); status != exitSuccess {
+		t.Fatalf("reset: want status %d, got %d", exitSuccess, status)
+	}
+	if _, err := os.Stat(statusPath); !os.IsNotExist(err) {
+		t.Fatalf("status file should be removed")
+	}
+}
+
+func TestDefaultStatusPath(t *testing.T) {
+	if got := defaultStatusPath("deploy/workflow.yaml"); got != "deploy/workflow.status.json" {
+		t.Fatalf("unexpected status path %s", got)
+	}
+}
+
+func TestRenderStatus(t *testing.T) {
+	status := &workflow.Status{
+		Started:       true,
+		Percent:       50,
+		CurrentGroups: []string{"group1"},
+		CurrentTasks:  []string{"task2"},
+		Groups: []*workflow.Group{
+			{
+				Id:      "group1",
+				Percent: 50,
+				Started: true,
+				Tasks: []*workflow.Task{
+					{Id: "task1", Started: true, Finished: true},
+					{Id: "task2", Started: true, Percent: 0.25, Attempt: 1, LastMessage: "copying\nfiles"},
+				},
+			},
+		},
+		Finally: []*workflow.Group{
+			{Id: "cleanup", Tasks: []*workflow.Task{{Id: "task1"}}},
+		},
+	}
+
+	b := &strings.Builder{}
+	lines := renderStatus(b, status)
+
+	want := `group1  [##########..........]  50%  running
+  task1 [####################] 100%  done
+  task2 [#####...............]  25%  running copying files
+50% running
+`
+	if b.String() != want {
+		t.Fatalf("want\n%s\ngot\n%s", want, b)
+	}
+	if lines != 4 {
+		t.Fatalf("want 4 lines, got %d", lines)
+	}
+}
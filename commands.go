@@ -0,0 +1,90 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/coder/websocket"
+)
+
+// Command is a command sent by websocket clients to control the workflow,
+// like:
+//
+//	{"id": "1", "command": "start", "inputs": {"ENVIRONMENT": "staging"}}
+//	{"id": "2", "command": "answer", "group": "group1", "task": "task1", "name": "CONFIRM", "value": "yes"}
+//
+// The start, continue, abort and reset commands behave like the actions of
+// [Workflow.ControlHandler], start and continue taking optional inputs like
+// [Workflow.StartWithInputs], and answer calls [Workflow.Answer]. Commands are
+// only accepted from clients with [PermissionControl], see
+// [Workflow.SetAuthorizer], and each of them gets a [Reply] sent to the
+// client only, along with the events.
+type Command struct {
+	Id      string `json:"id,omitempty"` // Identifier echoed in the reply
+	Command string `json:"command"`      // start, continue, abort, reset or answer
+
+	Inputs map[string]string `json:"inputs,omitempty"` // Inputs of start and continue
+
+	Group string `json:"group,omitempty"` // Group of the task prompting
+	Task  string `json:"task,omitempty"`  // Task prompting
+	Name  string `json:"name,omitempty"`  // Name of the prompt
+	Value string `json:"value,omitempty"` // Answer to the prompt
+}
+
+// Reply acknowledges a [Command] with type [EventAck], or reports why it
+// failed with type [EventNack].
+type Reply struct {
+	Type    EventType `json:"type"`
+	Id      string    `json:"id,omitempty"`
+	Command string    `json:"command"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// readCommands runs the commands sent by the client c on conn, with
+// permission, until it is gone or ctx is done.
+func (w *Workflow) readCommands(ctx context.Context, conn *websocket.Conn, c *client, permission Permission) {
+	for {
+		_, b, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+
+		cmd := &Command{}
+		err = json.Unmarshal(b, cmd)
+		if err != nil {
+			err = fmt.Errorf("invalid command: %w", err)
+		} else if permission < PermissionControl {
+			err = WorkflowErrorForbidden
+		} else {
+			err = w.command(cmd)
+		}
+
+		reply := Reply{Type: EventAck, Id: cmd.Id, Command: cmd.Command}
+		if err != nil {
+			reply.Type, reply.Error = EventNack, err.Error()
+		}
+		b, err = json.Marshal(&reply)
+		if err != nil {
+			return
+		}
+		w.clients.send(c, message{data: b})
+	}
+}
+
+// command runs cmd.
+func (w *Workflow) command(cmd *Command) error {
+	switch cmd.Command {
+	case "start":
+		return w.startBackground(false, cmd.Inputs)
+	case "continue":
+		return w.startBackground(true, cmd.Inputs)
+	case "abort":
+		return w.abort()
+	case "reset":
+		return w.Reset()
+	case "answer":
+		return w.Answer(cmd.Group, cmd.Task, cmd.Name, cmd.Value)
+	}
+	return fmt.Errorf("%w %q", WorkflowErrorUnknownCommand, cmd.Command)
+}
@@ -0,0 +1,208 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// wsMessage is an event or a reply read from a websocket.
+type wsMessage struct {
+	Event
+	Id      string `json:"id"`
+	Command string `json:"command"`
+}
+
+// make_commands_socket connects to the websocket handler fn and returns a
+// function sending a command and one reading messages until match returns
+// true.
+func make_commands_socket(t *testing.T, fn http.Handler) (func(cmd Command), func(match func(m *wsMessage) bool) *wsMessage) {
+	s := httptest.NewServer(fn)
+	t.Cleanup(s.Close)
+
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+
+	send := func(cmd Command) {
+		t.Helper()
+		if err := wsjson.Write(context.Background(), conn, &cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(match func(m *wsMessage) bool) *wsMessage {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		for {
+			m := &wsMessage{}
+			if err := wsjson.Read(ctx, conn, m); err != nil {
+				t.Fatal(err)
+			}
+			if match(m) {
+				return m
+			}
+		}
+	}
+	return send, read
+}
+
+// reply returns a matcher for the reply to command id.
+func reply(id string) func(m *wsMessage) bool {
+	return func(m *wsMessage) bool {
+		return (m.Type == EventAck || m.Type == EventNack) && m.Id == id
+	}
+}
+
+// event returns a matcher for events of type t.
+func event(t EventType) func(m *wsMessage) bool {
+	return func(m *wsMessage) bool { return m.Type == t }
+}
+
+// Test controlling the workflow and answering prompts over the websocket
+func TestCommands(t *testing.T) {
+	wf, fn, err := New("test_data/test-prompt.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.SetAuthorizer(AuthorizerFunc(func(r *http.Request) Permission { return PermissionControl }))
+
+	send, read := make_commands_socket(t, fn)
+
+	tests := []struct {
+		cmd  Command
+		want string
+	}{
+		{Command{Id: "1", Command: "abort"}, WorkflowErrorNotRunning.Error()},
+		{Command{Id: "2", Command: "reboot"}, `unknown command "reboot"`},
+		{Command{Id: "3", Command: "answer", Group: "group1", Task: "task1", Name: "CONFIRM"}, "no pending prompt CONFIRM for task group1/task1"},
+		{Command{Id: "4", Command: "start"}, ""},
+	}
+	for _, test := range tests {
+		send(test.cmd)
+		m := read(reply(test.cmd.Id))
+		if m.Error != test.want || m.Command != test.cmd.Command || (m.Type == EventAck) != (test.want == "") {
+			t.Fatalf("%s: want error %q, got %+v", test.cmd.Command, test.want, m)
+		}
+	}
+
+	prompt := read(event(EventPrompt))
+	if prompt.Group != "group1" || prompt.Task != "task1" || prompt.Name != "CONFIRM" || prompt.Message != "Deploy now?" {
+		t.Fatalf("unexpected prompt %+v", prompt)
+	}
+
+	send(Command{Id: "5", Command: "answer", Group: "group1", Task: "task1", Name: "CONFIRM", Value: "yes"})
+	if m := read(reply("5")); m.Type != EventAck {
+		t.Fatalf("unexpected reply %+v", m)
+	}
+
+	// Already answered
+	send(Command{Id: "6", Command: "answer", Group: "group1", Task: "task1", Name: "CONFIRM", Value: "no"})
+
+	got := []string{}
+	m := read(func(m *wsMessage) bool {
+		switch {
+		case m.Type == EventOutput:
+			got = append(got, m.Message)
+		case reply("6")(m) && m.Type != EventNack:
+			t.Fatalf("unexpected reply %+v", m)
+		}
+		return m.Type == EventWorkflowFinished
+	})
+	if m.Outcome != OutcomeSuccess {
+		t.Fatalf("unexpected outcome %+v", m)
+	}
+	if strings.Join(got, ", ") != "answered yes, next yes" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+// Test that commands are refused by default
+func TestCommandsDisabled(t *testing.T) {
+	wf, fn, err := New("test_data/test-prompt.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send, read := make_commands_socket(t, fn)
+	send(Command{Id: "1", Command: "start"})
+	m := read(reply("1"))
+	if m.Type != EventNack || m.Error != WorkflowErrorForbidden.Error() {
+		t.Fatalf("unexpected reply %+v", m)
+	}
+
+	send(Command{Id: "2", Command: "start"})
+	m = read(reply("2"))
+	if m.Type != EventNack {
+		t.Fatalf("unexpected reply %+v", m)
+	}
+
+	if wf.isRunning() {
+		t.Fatal("workflow started")
+	}
+}
+
+// Test prompts of custom executors
+func TestExecutionPrompt(t *testing.T) {
+	wf, _, err := New("test_data/test-executors.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.Status.Vars = map[string]string{"NAME": "world"}
+
+	answers := make(chan string, 1)
+	wf.RegisterExecutor("download", ExecutorFunc(func(ctx context.Context, e *Execution) error {
+		answer, err := e.Prompt(ctx, "GREETING", "How to greet?")
+		if err != nil {
+			return err
+		}
+		answers <- answer
+		return nil
+	}))
+
+	done := make(chan error)
+	go func() { done <- wf.Start() }()
+
+	// Wait for the prompt
+	for {
+		wf.Lock()
+		prompt := wf.Status.Groups[0].Tasks[1].Prompt
+		wf.Unlock()
+		if prompt != nil {
+			if prompt.Question != "How to greet?" {
+				t.Fatalf("unexpected prompt %+v", prompt)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = wf.Answer("group1", "download", "OTHER", "hi")
+	if !errors.Is(err, WorkflowErrorNoPrompt) {
+		t.Fatalf("want %v, got %v", WorkflowErrorNoPrompt, err)
+	}
+	err = wf.Answer("group1", "download", "GREETING", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := <-answers; got != "hi" {
+		t.Fatalf("want %q, got %q", "hi", got)
+	}
+	if wf.Status.Vars["GREETING"] != "hi" {
+		t.Fatalf("answer not published, got %v", wf.Status.Vars)
+	}
+}
@@ -0,0 +1,179 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+)
+
+// ControlHandler returns an http.Handler to control the workflow with a REST
+// API:
+//
+//	POST start     starts the workflow, with optional inputs
+//	POST continue  continues a workflow interrupted by an exits task, with optional inputs
+//	POST abort     aborts the running workflow
+//	POST reset     resets a finished workflow
+//	GET  status    returns the current status
+//
+// Routes are matched on the last element of the request path, so the handler
+// can be mounted under any prefix, with or without [http.StripPrefix]:
+//
+//	http.Handle("/api/workflow/", wf.ControlHandler())
+//
+// The body of start and continue can give the inputs of the workflow, like
+// {"inputs": {"ENVIRONMENT": "staging"}}, see [Workflow.StartWithInputs] and
+// [Workflow.ContinueWithInputs]. Invalid inputs fail with 400 Bad Request.
+//
+// Start and continue return 202 Accepted as soon as the workflow runs in the
+// background, and the other actions 200 OK, all with the current status as
+// body. Actions that are not possible in the current state of the workflow,
+// like starting it twice, fail with 409 Conflict and a JSON body like
+// {"error": "workflow already running"}.
+//
+// Status needs [PermissionRead] and the other actions [PermissionControl],
+// see [Workflow.SetAuthorizer].
+func (w *Workflow) ControlHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		permission := w.authorize(r, PermissionControl)
+		if permission == PermissionNone {
+			writeError(rw, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		action := path.Base(r.URL.Path)
+
+		method := http.MethodPost
+		if action == "status" {
+			method = http.MethodGet
+		}
+
+		var err error
+		switch action {
+		case "start", "continue", "abort", "reset", "status":
+		default:
+			writeError(rw, http.StatusNotFound, errors.New("unknown action "+action))
+			return
+		}
+		if r.Method != method {
+			rw.Header().Set("Allow", method)
+			writeError(rw, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		if action != "status" && permission < PermissionControl {
+			writeError(rw, http.StatusForbidden, WorkflowErrorForbidden)
+			return
+		}
+
+		code := http.StatusOK
+		switch action {
+		case "start", "continue":
+			body := struct {
+				Inputs map[string]string `json:"inputs"`
+			}{}
+			err = json.NewDecoder(r.Body).Decode(&body)
+			if err != nil && !errors.Is(err, io.EOF) {
+				writeError(rw, http.StatusBadRequest, fmt.Errorf("%w: %w", WorkflowErrorInvalidInput, err))
+				return
+			}
+			err = w.startBackground(action == "continue", body.Inputs)
+			code = http.StatusAccepted
+		case "abort":
+			err = w.abort()
+		case "reset":
+			err = w.Reset()
+		}
+
+		switch {
+		case err == nil:
+		case errors.Is(err, WorkflowErrorInvalidInput):
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		case errors.Is(err, WorkflowErrorRunning),
+			errors.Is(err, WorkflowErrorNotRunning),
+			errors.Is(err, WorkflowErrorNotFinished),
+			errors.Is(err, WorkflowErrorFinished),
+			errors.Is(err, WorkflowErrorStarted),
+			errors.Is(err, os.ErrNotExist):
+			writeError(rw, http.StatusConflict, err)
+			return
+		default:
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Lock()
+		b, err := json.Marshal(&w.Status)
+		w.Unlock()
+		if err != nil {
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(code)
+		_, _ = rw.Write(b)
+	})
+}
+
+// startBackground checks that the workflow can be started, or continued if
+// resume is true, and runs it in the background.
+func (w *Workflow) startBackground(resume bool, inputs map[string]string) error {
+	if resume {
+		if _, err := os.Stat(w.statusPath); err != nil {
+			return err
+		}
+	}
+
+	// The status only changes while the workflow runs, which begin guards
+	// against
+	w.Lock()
+	active, started, finished := w.active, w.Status.Started, w.Status.Finished
+	w.Unlock()
+	switch {
+	case active:
+		return WorkflowErrorRunning
+	case finished:
+		return WorkflowErrorFinished
+	case started && !resume:
+		return WorkflowErrorStarted
+	}
+
+	err := w.checkInputs(inputs)
+	if err != nil {
+		return err
+	}
+
+	err = w.begin()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer w.end()
+		if err := w.start(inputs); err != nil {
+			slog.Error("workflow ended", "error", err)
+		}
+	}()
+	return nil
+}
+
+// abort aborts the workflow if it is running.
+func (w *Workflow) abort() error {
+	if !w.isRunning() {
+		return WorkflowErrorNotRunning
+	}
+	w.Abort()
+	return nil
+}
+
+// writeError writes err as a JSON object with status code.
+func writeError(rw http.ResponseWriter, code int, err error) {
+	b, _ := json.Marshal(map[string]string{"error": err.Error()})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	_, _ = rw.Write(b)
+}
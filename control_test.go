@@ -0,0 +1,213 @@
+package workflow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestControlHandler(t *testing.T) {
+	wf, _, err := New("test_data/test-control.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mounted under a prefix without stripping it
+	mux := http.NewServeMux()
+	mux.Handle("/api/workflow/", wf.ControlHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	request := func(method, action string, want int) map[string]any {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+"/api/workflow/"+action, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body := map[string]any{}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: want %d, got %d %v", method, action, want, resp.StatusCode, body)
+		}
+		return body
+	}
+
+	waitFinished := func() map[string]any {
+		t.Helper()
+		for range 200 {
+			status := request(http.MethodGet, "status", http.StatusOK)
+			if status["finished"] == true && !wf.isRunning() {
+				return status
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("workflow didn't finish")
+		return nil
+	}
+
+	if status := request(http.MethodGet, "status", http.StatusOK); status["started"] != false {
+		t.Fatalf("workflow should not be started %v", status)
+	}
+
+	// Nothing to continue, abort or reset before the workflow runs
+	request(http.MethodPost, "continue", http.StatusConflict)
+	request(http.MethodPost, "abort", http.StatusConflict)
+	body := request(http.MethodPost, "reset", http.StatusConflict)
+	if body["error"] != WorkflowErrorNotFinished.Error() {
+		t.Fatalf("unexpected error %v", body)
+	}
+
+	request(http.MethodPost, "start", http.StatusAccepted)
+
+	// Guard against double starts
+	body = request(http.MethodPost, "start", http.StatusConflict)
+	if body["error"] != WorkflowErrorRunning.Error() {
+		t.Fatalf("unexpected error %v", body)
+	}
+	request(http.MethodPost, "reset", http.StatusConflict)
+
+	request(http.MethodGet, "start", http.StatusMethodNotAllowed)
+	request(http.MethodPost, "status", http.StatusMethodNotAllowed)
+	request(http.MethodPost, "unknown", http.StatusNotFound)
+
+	request(http.MethodPost, "abort", http.StatusOK)
+	if status := waitFinished(); status["outcome"] != string(OutcomeAborted) {
+		t.Fatalf("want outcome %s, got %v", OutcomeAborted, status["outcome"])
+	}
+
+	// A finished workflow must be reset before it starts again
+	body = request(http.MethodPost, "start", http.StatusConflict)
+	if body["error"] != WorkflowErrorFinished.Error() {
+		t.Fatalf("unexpected error %v", body)
+	}
+	if status := request(http.MethodPost, "reset", http.StatusOK); status["started"] != false {
+		t.Fatalf("workflow should be reset %v", status)
+	}
+	request(http.MethodPost, "start", http.StatusAccepted)
+	request(http.MethodPost, "abort", http.StatusOK)
+	waitFinished()
+}
+
+// Test passing inputs to start
+func TestControlHandlerInputs(t *testing.T) {
+	statusPath := path.Join(t.TempDir(), "status.json")
+	wf, _, err := New("test_data/test-inputs.yaml", statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(wf.ControlHandler())
+	defer srv.Close()
+
+	// Inputs of continue are checked like the ones of start
+	err = os.WriteFile(statusPath, []byte("{}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(srv.URL+"/continue", "application/json", strings.NewReader(`{"inputs": {"VERSION": "1.0"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("want %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	err = os.Remove(statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"inputs": {"ENVIRONMENT": "test"}}`, http.StatusBadRequest},
+		{`{"inputs": "staging"}`, http.StatusBadRequest},
+		{``, http.StatusBadRequest},
+		{`{"inputs": {"ENVIRONMENT": "staging"}}`, http.StatusAccepted},
+	}
+	for _, test := range tests {
+		resp, err := http.Post(srv.URL+"/start", "application/json", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.want {
+			t.Fatalf("%q: want %d, got %d", test.body, test.want, resp.StatusCode)
+		}
+	}
+
+	for wf.isRunning() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := wf.Status.Groups[0].Tasks[0].LastMessage; got != "staging 2 eu-west-1" {
+		t.Fatalf("unexpected message %q", got)
+	}
+}
+
+// Test resetting the workflow while its status is read
+func TestControlHandlerReset(t *testing.T) {
+	wf, _, err := New("test_data/test-output.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(wf.ControlHandler())
+	defer srv.Close()
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one of the concurrent resets finds the workflow finished
+	codes := make(chan int, 10)
+	wg := sync.WaitGroup{}
+	for range 5 {
+		for _, action := range []string{"reset", "status"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				method := http.MethodPost
+				if action == "status" {
+					method = http.MethodGet
+				}
+				req, _ := http.NewRequest(method, srv.URL+"/"+action, nil)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+				if action == "reset" {
+					codes <- resp.StatusCode
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(codes)
+
+	accepted := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			accepted++
+		}
+	}
+	if accepted != 1 || wf.Status.Started {
+		t.Fatalf("want one reset, got %d, started %v", accepted, wf.Status.Started)
+	}
+}
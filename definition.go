@@ -0,0 +1,656 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Definition is the content of a workflow definition file.
+//
+// Definitions are decoded strictly: unknown fields and values of the wrong
+// type are reported as [DefinitionError] with their position in the file.
+type Definition struct {
+	Vars       VarDefinitions     `yaml:"vars" json:"vars,omitempty"`
+	Env        map[string]string  `yaml:"env" json:"env,omitempty"`
+	InheritEnv *InheritEnv        `yaml:"inherit_env" json:"inherit_env,omitempty"`
+	Timeout    Duration           `yaml:"timeout" json:"timeout,omitempty"`
+	Groups     []*GroupDefinition `yaml:"groups" json:"groups"`
+	OnSuccess  []*GroupDefinition `yaml:"on_success" json:"on_success,omitempty"`
+	OnFailure  []*GroupDefinition `yaml:"on_failure" json:"on_failure,omitempty"`
+	Finally    []*GroupDefinition `yaml:"finally" json:"finally,omitempty"`
+
+	pos Position
+}
+
+// VarDefinition is the definition of a workflow variable.
+//
+// The initial value of a variable is a literal `value`, the output of a
+// command, or comes `from` a secret provider. Variables without any of them
+// are inputs: their value is given when the workflow is started with
+// [Workflow.StartWithInputs], or is their `default`, and starting fails if a
+// `required` input is missing. Inputs can also override the value of other
+// variables.
+//
+// Values are checked against the `type` of the variable, `string` by
+// default, `int`, `bool`, or `enum` for one of `values`.
+//
+// The value of a secret variable is exported to the environment of tasks
+// like any other, but it is masked in the status, in events and in messages
+// sent by tasks, and it is not written to the status file. Variables whose
+// value comes `from` a [SecretProvider] are always secret.
+type VarDefinition struct {
+	Name        string   `yaml:"-" json:"-"`
+	Description string   `yaml:"description" json:"description,omitempty"` // Description of the variable, for input forms
+	Type        string   `yaml:"type" json:"type,omitempty"`               // string, int, bool or enum
+	Values      []string `yaml:"values" json:"values,omitempty"`           // Allowed values of enum variables
+	Value       string   `yaml:"value" json:"value,omitempty"`             // Literal initial value of the variable
+	Cmd         string   `yaml:"cmd" json:"cmd,omitempty"`                 // Command whose output is the initial value of the variable
+	From        string   `yaml:"from" json:"from,omitempty"`               // Secret provider and reference of the value, like env:TOKEN
+	Default     string   `yaml:"default" json:"default,omitempty"`         // Value of the input when it is not given
+	Required    bool     `yaml:"required" json:"required,omitempty"`       // Input must be given
+	Secret      bool     `yaml:"secret" json:"secret,omitempty"`           // Value must not leave the process
+
+	pos Position
+}
+
+// VarDefinitions are the variables of a workflow, in declaration order. They
+// are written as a mapping of names to commands, or to a mapping with the
+// command and options of the variable:
+//
+//	vars:
+//	  OS: uname
+//	  TOKEN:
+//	    cmd: cat /run/secrets/token
+//	    secret: true
+//	  PASSWORD:
+//	    from: env:DB_PASSWORD
+//	  ENVIRONMENT:
+//	    type: enum
+//	    values: [staging, production]
+//	    required: true
+type VarDefinitions []*VarDefinition
+
+func (v *VarDefinitions) decodeYAML(d *decoder, n *yaml.Node, err error) {
+	if n.Kind != yaml.MappingNode {
+		d.errorf(n, err, "expected a mapping, got %s", describe(n))
+		return
+	}
+	result := VarDefinitions{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if slices.ContainsFunc(result, func(v *VarDefinition) bool { return v.Name == key.Value }) {
+			d.errorf(key, err, "duplicate variable %q", key.Value)
+			continue
+		}
+		def := &VarDefinition{Name: key.Value}
+		switch value.Kind {
+		case yaml.ScalarNode:
+			def.Cmd = value.Value
+		case yaml.MappingNode:
+			d.decode(value, reflect.ValueOf(def).Elem(), err)
+		default:
+			d.errorf(value, err, "expected a command or a mapping, got %s", describe(value))
+			continue
+		}
+		def.pos = d.position(key)
+		result = append(result, def)
+	}
+	*v = result
+}
+
+// secret returns true if variable name is declared as secret.
+func (v VarDefinitions) secret(name string) bool {
+	def := v.find(name)
+	return def != nil && def.secret()
+}
+
+// secret returns true if the value of the variable must be masked.
+func (def *VarDefinition) secret() bool {
+	return def.Secret || def.From != ""
+}
+
+// input returns true if the value of the variable is given when starting the
+// workflow.
+func (def *VarDefinition) input() bool {
+	return def.Value == "" && def.Cmd == "" && def.From == ""
+}
+
+// varReferenceRegexp matches references to variables in commands, like $OS or
+// ${OS}.
+var varReferenceRegexp = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// dependencies returns the names of the variables, and for each of them the
+// other variables its command references, which must be initialized first.
+func (v VarDefinitions) dependencies() ([]string, [][]string) {
+	ids := []string{}
+	dependsOn := [][]string{}
+	for _, def := range v {
+		refs := []string{}
+		for _, m := range varReferenceRegexp.FindAllStringSubmatch(def.Cmd, -1) {
+			name := m[1] + m[2]
+			if name != def.Name && v.find(name) != nil && !slices.Contains(refs, name) {
+				refs = append(refs, name)
+			}
+		}
+		ids = append(ids, def.Name)
+		dependsOn = append(dependsOn, refs)
+	}
+	return ids, dependsOn
+}
+
+// find returns the definition of variable name, or nil.
+func (v VarDefinitions) find(name string) *VarDefinition {
+	i := slices.IndexFunc(v, func(def *VarDefinition) bool { return def.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return v[i]
+}
+
+func (v VarDefinitions) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	b.WriteByte('{')
+	for i, def := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(def.Name)
+		if err != nil {
+			return nil, err
+		}
+		// Variables only defined by a command are written in the short form
+		var value []byte
+		if reflect.DeepEqual(def, &VarDefinition{Name: def.Name, Cmd: def.Cmd, pos: def.pos}) {
+			value, err = json.Marshal(def.Cmd)
+		} else {
+			value, err = json.Marshal(def)
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func (v *VarDefinitions) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		*v = nil
+		return nil
+	}
+	if t != json.Delim('{') {
+		return WorkflowErrorInvalidVars
+	}
+	result := VarDefinitions{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		def := &VarDefinition{Name: t.(string)}
+		raw := json.RawMessage{}
+		err = dec.Decode(&raw)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(raw, []byte("{")) {
+			err = json.Unmarshal(raw, def)
+		} else {
+			err = json.Unmarshal(raw, &def.Cmd)
+		}
+		if err != nil {
+			return err
+		}
+		result = append(result, def)
+	}
+	*v = result
+	return nil
+}
+
+// GroupDefinition is the definition of a [Group].
+type GroupDefinition struct {
+	Id              string            `yaml:"id" json:"id"`
+	Skip            bool              `yaml:"skip" json:"skip,omitempty"`
+	SkipCmd         string            `yaml:"skip_cmd" json:"skip_cmd,omitempty"`
+	When            string            `yaml:"when" json:"when,omitempty"`
+	Parallel        bool              `yaml:"parallel" json:"parallel,omitempty"`
+	MaxConcurrency  int               `yaml:"max_concurrency" json:"max_concurrency,omitempty"`
+	DependsOn       []string          `yaml:"depends_on" json:"depends_on"` // nil and empty have different meanings
+	Timeout         Duration          `yaml:"timeout" json:"timeout,omitempty"`
+	ContinueOnError bool              `yaml:"continue_on_error" json:"continue_on_error,omitempty"`
+	Env             map[string]string `yaml:"env" json:"env,omitempty"`
+	InheritEnv      *InheritEnv       `yaml:"inherit_env" json:"inherit_env,omitempty"`
+	Tasks           []*TaskDefinition `yaml:"tasks" json:"tasks"`
+
+	pos Position
+}
+
+// TaskDefinition is the definition of a [Task].
+type TaskDefinition struct {
+	Id           string            `yaml:"id" json:"id"`
+	Type         string            `yaml:"type" json:"type,omitempty"`
+	Cmd          string            `yaml:"cmd" json:"cmd,omitempty"`
+	Args         []string          `yaml:"args" json:"args,omitempty"`
+	With         map[string]any    `yaml:"with" json:"with,omitempty"`
+	Weight       int               `yaml:"weight" json:"weight,omitempty"`
+	Exits        bool              `yaml:"exits" json:"exits,omitempty"`
+	Skip         bool              `yaml:"skip" json:"skip,omitempty"`
+	SkipCmd      string            `yaml:"skip_cmd" json:"skip_cmd,omitempty"`
+	When         string            `yaml:"when" json:"when,omitempty"`
+	DependsOn    []string          `yaml:"depends_on" json:"depends_on"` // nil and empty have different meanings
+	Retries      int               `yaml:"retries" json:"retries,omitempty"`
+	RetryDelay   Duration          `yaml:"retry_delay" json:"retry_delay,omitempty"`
+	Backoff      float64           `yaml:"backoff" json:"backoff,omitempty"`
+	Timeout      Duration          `yaml:"timeout" json:"timeout,omitempty"`
+	AllowFailure bool              `yaml:"allow_failure" json:"allow_failure,omitempty"`
+	Env          map[string]string `yaml:"env" json:"env,omitempty"`
+	InheritEnv   *InheritEnv       `yaml:"inherit_env" json:"inherit_env,omitempty"`
+
+	pos Position
+}
+
+func (d *Definition) setPosition(p Position)      { d.pos = p }
+func (d *GroupDefinition) setPosition(p Position) { d.pos = p }
+func (d *TaskDefinition) setPosition(p Position)  { d.pos = p }
+
+// Duration is a duration in a workflow definition, written either as a string
+// like "1m30s" or as a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) decodeYAML(dec *decoder, n *yaml.Node, err error) {
+	var value any
+	if n.Kind != yaml.ScalarNode || n.Decode(&value) != nil {
+		dec.errorf(n, err, "expected a duration, got %s", describe(n))
+		return
+	}
+	result, ok := duration(value)
+	if !ok {
+		dec.errorf(n, err, "invalid duration %q", n.Value)
+		return
+	}
+	*d = Duration(result)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+	result, ok := duration(v)
+	if !ok {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	*d = Duration(result)
+	return nil
+}
+
+// duration converts a decoded Duration value, either a string like "1m30s"
+// or a number of seconds, to a time.Duration. It returns false if v can't be
+// converted.
+func duration(v any) (time.Duration, bool) {
+	switch v := v.(type) {
+	case nil:
+		return 0, true
+	case int:
+		return time.Duration(v) * time.Second, v >= 0
+	case float64:
+		return time.Duration(v * float64(time.Second)), v >= 0
+	case string:
+		d, err := time.ParseDuration(v)
+		return d, err == nil && d >= 0
+	}
+	return 0, false
+}
+
+// InheritEnv is the `inherit_env` policy selecting the environment variables
+// of the program inherited by the commands of a workflow, group or task,
+// written `all`, `none`, or as a list of names.
+type InheritEnv struct {
+	All   bool     // Inherit every variable
+	Names []string // Inherited variables when All is false
+}
+
+func (e *InheritEnv) decodeYAML(d *decoder, n *yaml.Node, err error) {
+	switch {
+	case n.Kind == yaml.ScalarNode && n.Value == "all":
+		*e = InheritEnv{All: true}
+	case n.Kind == yaml.ScalarNode && n.Value == "none":
+		*e = InheritEnv{}
+	case n.Kind == yaml.SequenceNode:
+		*e = InheritEnv{}
+		d.decode(n, reflect.ValueOf(&e.Names).Elem(), err)
+	default:
+		d.errorf(n, err, "expected all, none or a list of names, got %s", describe(n))
+	}
+}
+
+func (e InheritEnv) MarshalJSON() ([]byte, error) {
+	switch {
+	case e.All:
+		return json.Marshal("all")
+	case len(e.Names) == 0:
+		return json.Marshal("none")
+	}
+	return json.Marshal(e.Names)
+}
+
+func (e *InheritEnv) UnmarshalJSON(b []byte) error {
+	var policy string
+	if json.Unmarshal(b, &policy) == nil {
+		switch policy {
+		case "all":
+			*e = InheritEnv{All: true}
+		case "none":
+			*e = InheritEnv{}
+		default:
+			return fmt.Errorf("invalid inherit_env %s", b)
+		}
+		return nil
+	}
+	*e = InheritEnv{}
+	return json.Unmarshal(b, &e.Names)
+}
+
+// Position is a position in a workflow definition file.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// wrap returns err as a [DefinitionError] at position p, or err unchanged if
+// p is unknown, like for definitions that were not read from a file.
+func (p Position) wrap(err error) error {
+	if p == (Position{}) {
+		return err
+	}
+	return &DefinitionError{Pos: p, Err: err}
+}
+
+// DefinitionError is returned when a workflow definition is invalid. It wraps
+// one of the WorkflowError errors describing the problem.
+type DefinitionError struct {
+	Pos Position // Position of the problem
+	Err error
+}
+
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Err)
+}
+
+func (e *DefinitionError) Unwrap() error { return e.Err }
+
+// loadDefinition reads and decodes the workflow definition file at path.
+func loadDefinition(path string) (*Definition, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDefinition(path, b)
+}
+
+// parseDefinition decodes the workflow definition b read from file. All
+// decoding errors are returned joined.
+func parseDefinition(file string, b []byte) (*Definition, error) {
+	result, errs := decodeDefinition(file, b)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return result, nil
+}
+
+// decodeDefinition decodes the workflow definition b read from file. It
+// returns the definition decoded despite errors, if b is valid yaml, and all
+// decoding errors.
+func decodeDefinition(file string, b []byte) (*Definition, []error) {
+	root := yaml.Node{}
+	err := yaml.Unmarshal(b, &root)
+	if err != nil {
+		return nil, []error{&DefinitionError{Pos: Position{File: file}, Err: err}}
+	}
+
+	result := &Definition{pos: Position{File: file}}
+	d := &decoder{file: file}
+	d.decode(&root, reflect.ValueOf(result).Elem(), WorkflowErrorInvalidDefinition)
+
+	return result, d.errs
+}
+
+// fieldErrors are the errors wrapped by decoding errors in the value of some
+// fields, instead of [WorkflowErrorInvalidDefinition].
+var fieldErrors = map[string]error{
+	"vars":        WorkflowErrorInvalidVars,
+	"env":         WorkflowErrorInvalidEnv,
+	"inherit_env": WorkflowErrorInvalidEnv,
+	"timeout":     WorkflowErrorInvalidTimeout,
+	"on_success":  WorkflowErrorInvalidHooks,
+	"on_failure":  WorkflowErrorInvalidHooks,
+	"finally":     WorkflowErrorInvalidHooks,
+	"depends_on":  WorkflowErrorInvalidDependencies,
+	"args":        WorkflowErrorTaskInvalidArgs,
+	"retries":     WorkflowErrorInvalidRetries,
+	"retry_delay": WorkflowErrorInvalidRetries,
+	"backoff":     WorkflowErrorInvalidRetries,
+}
+
+// decoder decodes a yaml node tree into definitions, using the yaml field
+// tags. Unlike yaml.Node.Decode, it rejects unknown fields and records every
+// error with its position instead of stopping at the first one.
+type decoder struct {
+	file string
+	errs []error
+}
+
+func (d *decoder) position(n *yaml.Node) Position {
+	return Position{File: d.file, Line: n.Line, Column: n.Column}
+}
+
+func (d *decoder) errorf(n *yaml.Node, err error, format string, args ...any) {
+	d.errs = append(d.errs, &DefinitionError{
+		Pos: d.position(n),
+		Err: fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...)),
+	})
+}
+
+// decode decodes n into v. Errors wrap err, unless they are found in a field
+// listed in fieldErrors.
+func (d *decoder) decode(n *yaml.Node, v reflect.Value, err error) {
+	switch n.Kind {
+	case 0:
+		// Empty document
+		return
+	case yaml.DocumentNode:
+		if len(n.Content) > 0 {
+			d.decode(n.Content[0], v, err)
+		}
+		return
+	case yaml.AliasNode:
+		d.decode(n.Alias, v, err)
+		return
+	}
+
+	// Null values leave fields unset
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return
+	}
+
+	// Types with their own decoding
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(interface {
+			decodeYAML(d *decoder, n *yaml.Node, err error)
+		}); ok {
+			u.decodeYAML(d, n, err)
+			return
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		d.decode(n, v.Elem(), err)
+
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			d.errorf(n, err, "expected a mapping, got %s", describe(n))
+			return
+		}
+		if p, ok := v.Addr().Interface().(interface{ setPosition(Position) }); ok {
+			p.setPosition(d.position(n))
+		}
+
+		fields := map[string]int{}
+		for i, name := range definitionFields(v.Type()) {
+			if name != "" {
+				fields[name] = i
+			}
+		}
+
+		seen := map[string]bool{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if seen[key.Value] {
+				d.errorf(key, WorkflowErrorInvalidDefinition, "duplicate field %q", key.Value)
+				continue
+			}
+			seen[key.Value] = true
+
+			field, ok := fields[key.Value]
+			if !ok {
+				d.errorf(key, WorkflowErrorUnknownField, "%q in %s", key.Value, v.Type().Name())
+				continue
+			}
+
+			fieldErr := err
+			if e, ok := fieldErrors[key.Value]; ok {
+				fieldErr = e
+			}
+			d.decode(value, v.Field(field), fieldErr)
+		}
+
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			d.errorf(n, err, "expected a list, got %s", describe(n))
+			return
+		}
+		result := reflect.MakeSlice(v.Type(), len(n.Content), len(n.Content))
+		for i := range n.Content {
+			d.decode(n.Content[i], result.Index(i), err)
+		}
+		v.Set(result)
+
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			d.errorf(n, err, "expected a mapping, got %s", describe(n))
+			return
+		}
+
+		// Free form values, like executor parameters
+		if v.Type().Elem().Kind() == reflect.Interface {
+			if e := n.Decode(v.Addr().Interface()); e != nil {
+				d.errorf(n, err, "%s", e)
+			}
+			return
+		}
+
+		result := reflect.MakeMap(v.Type())
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if result.MapIndex(reflect.ValueOf(key.Value)).IsValid() {
+				d.errorf(key, err, "duplicate key %q", key.Value)
+				continue
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			d.decode(value, elem, err)
+			result.SetMapIndex(reflect.ValueOf(key.Value), elem)
+		}
+		v.Set(result)
+
+	case reflect.String:
+		if n.Kind != yaml.ScalarNode {
+			d.errorf(n, err, "expected a string, got %s", describe(n))
+			return
+		}
+		v.SetString(n.Value)
+
+	case reflect.Bool:
+		var b bool
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!bool" || n.Decode(&b) != nil {
+			d.errorf(n, err, "expected a boolean, got %s", describe(n))
+			return
+		}
+		v.SetBool(b)
+
+	case reflect.Int:
+		var i int
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!int" || n.Decode(&i) != nil {
+			d.errorf(n, err, "expected an integer, got %s", describe(n))
+			return
+		}
+		v.SetInt(int64(i))
+
+	case reflect.Float64:
+		var f float64
+		if n.Kind != yaml.ScalarNode || (n.Tag != "!!int" && n.Tag != "!!float") || n.Decode(&f) != nil {
+			d.errorf(n, err, "expected a number, got %s", describe(n))
+			return
+		}
+		v.SetFloat(f)
+
+	default:
+		panic("unsupported definition type " + v.Type().String())
+	}
+}
+
+// definitionFields returns the yaml names of the fields of struct type t,
+// indexed by field number. Fields that are not decoded have an empty name.
+func definitionFields(t reflect.Type) []string {
+	result := make([]string, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "-" {
+			result[i] = name
+		}
+	}
+	return result
+}
+
+// describe returns a short description of n for error messages.
+func describe(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", n.Value)
+}
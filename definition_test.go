@@ -0,0 +1,255 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefinition(t *testing.T) {
+	definition, err := loadDefinition("test_data/test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(definition.Vars) != 2 || definition.Vars[0].Name != "VAR1" || definition.Vars[0].Cmd != "echo var1" {
+		t.Fatalf("unexpected vars %v", definition.Vars)
+	}
+	if len(definition.Groups) != 2 || len(definition.Groups[1].Tasks) != 2 {
+		t.Fatalf("unexpected groups %+v", definition.Groups)
+	}
+	task := definition.Groups[1].Tasks[0]
+	if task.Id != "task1" || !task.Skip || task.Weight != 10 {
+		t.Fatalf("unexpected task %+v", task)
+	}
+	if got := task.pos.String(); got != "test_data/test.yaml:22:9" {
+		t.Fatalf("unexpected position %s", got)
+	}
+}
+
+func TestDefinitionJSON(t *testing.T) {
+	definition, err := parseDefinition("test.yaml", []byte(`
+timeout: 90
+vars:
+  OS: uname
+  TOKEN:
+    cmd: cat token
+    secret: true
+inherit_env: [PATH, HOME]
+groups:
+  - id: group1
+    inherit_env: all
+    tasks:
+      - id: task1
+        cmd: "true"
+        depends_on: []
+        retry_delay: 1m
+        inherit_env: none
+        env:
+          LANG: C
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &Definition{}
+	err = json.Unmarshal(b, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Vars) != 2 || result.Vars[0].Cmd != "uname" || result.Vars[0].Secret || result.Vars[1].Cmd != "cat token" || !result.Vars[1].Secret {
+		t.Fatalf("unexpected vars %+v %+v", result.Vars[0], result.Vars[1])
+	}
+	if time.Duration(result.Timeout) != 90*time.Second {
+		t.Fatalf("unexpected timeout %v", result.Timeout)
+	}
+	task := result.Groups[0].Tasks[0]
+	if task.DependsOn == nil || len(task.DependsOn) != 0 {
+		t.Fatalf("empty depends_on should be preserved, got %#v", task.DependsOn)
+	}
+	if time.Duration(task.RetryDelay) != time.Minute {
+		t.Fatalf("unexpected retry_delay %v", task.RetryDelay)
+	}
+	if !slices.Equal(result.InheritEnv.Names, []string{"PATH", "HOME"}) || !result.Groups[0].InheritEnv.All {
+		t.Fatalf("unexpected inherit_env %+v %+v", result.InheritEnv, result.Groups[0].InheritEnv)
+	}
+	if task.InheritEnv == nil || task.InheritEnv.All || len(task.InheritEnv.Names) != 0 || task.Env["LANG"] != "C" {
+		t.Fatalf("unexpected task environment %+v %v", task.InheritEnv, task.Env)
+	}
+}
+
+func TestDefinitionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want error
+		pos  string
+	}{
+		{"unknown field", `
+groups:
+  - id: group1
+    tasks:
+      - id: task1
+        cmd: "true"
+        skipp: true
+`, WorkflowErrorUnknownField, "test.yaml:7:9"},
+		{"not a mapping", `
+groups: [group1]
+`, WorkflowErrorInvalidDefinition, "test.yaml:2:10"},
+		{"invalid weight", `
+groups:
+  - id: group1
+    tasks:
+      - id: task1
+        cmd: "true"
+        weight: heavy
+`, WorkflowErrorInvalidDefinition, "test.yaml:7:17"},
+		{"invalid depends_on", `
+groups:
+  - id: group1
+    depends_on: group0
+    tasks: []
+`, WorkflowErrorInvalidDependencies, "test.yaml:4:17"},
+		{"invalid timeout", `
+timeout: forever
+groups: []
+`, WorkflowErrorInvalidTimeout, "test.yaml:2:10"},
+		{"invalid vars", `
+vars:
+  OS: [uname]
+groups: []
+`, WorkflowErrorInvalidVars, "test.yaml:3:7"},
+		{"unknown var field", `
+vars:
+  TOKEN:
+    cmd: cat token
+    secrett: true
+groups: []
+`, WorkflowErrorUnknownField, "test.yaml:5:5"},
+		{"invalid from", `
+vars:
+  TOKEN:
+    from: vault
+groups: []
+`, WorkflowErrorInvalidVars, "test.yaml:3:3"},
+		{"invalid default", `
+vars:
+  REPLICAS:
+    type: int
+    default: many
+groups: []
+`, WorkflowErrorInvalidVars, "test.yaml:3:3"},
+		{"missing enum values", `
+vars:
+  ENVIRONMENT:
+    type: enum
+groups: []
+`, WorkflowErrorInvalidVars, "test.yaml:3:3"},
+		{"several sources", `
+vars:
+  OS:
+    value: Linux
+    cmd: uname
+groups: []
+`, WorkflowErrorInvalidVars, "test.yaml:3:3"},
+		{"vars cycle", `
+vars:
+  A: echo $B
+  B: echo ${A}
+groups: []
+`, WorkflowErrorDependencyCycle, "test.yaml:4:3"},
+		{"invalid env", `
+groups:
+  - id: group1
+    env:
+      1PATH: /bin
+    tasks: []
+`, WorkflowErrorInvalidEnv, "test.yaml:3:5"},
+		{"invalid inherit_env", `
+inherit_env: some
+groups: []
+`, WorkflowErrorInvalidEnv, "test.yaml:2:14"},
+		{"concurrent exits", `
+groups:
+  - id: a
+    tasks:
+      - id: reboot
+        cmd: reboot
+        exits: true
+  - id: b
+    depends_on: []
+    tasks:
+      - id: task1
+        cmd: "true"
+`, WorkflowErrorConcurrentExits, "test.yaml:5:9"},
+		{"invalid hooks", `
+groups: []
+finally: cleanup
+`, WorkflowErrorInvalidHooks, "test.yaml:3:10"},
+		{"duplicate field", `
+groups: []
+groups: []
+`, WorkflowErrorInvalidDefinition, "test.yaml:3:1"},
+		{"missing cmd", `
+groups:
+  - id: group1
+    tasks:
+      - id: task1
+`, WorkflowErrorTaskMissingCommand, "test.yaml:5:9"},
+		{"missing tasks", `
+groups:
+  - id: group1
+`, WorkflowErrorGroupMissingTasks, "test.yaml:3:5"},
+		{"syntax", `
+groups: [
+`, nil, "test.yaml"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			definition, err := parseDefinition("test.yaml", []byte(test.yaml))
+			if err == nil {
+				err = errors.Join(definition.check()...)
+			}
+
+			var definitionErr *DefinitionError
+			if !errors.As(err, &definitionErr) {
+				t.Fatalf("want a DefinitionError, got %v", err)
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Fatalf("want %v, got %v", test.want, err)
+			}
+			if !strings.HasPrefix(err.Error(), test.pos+":") {
+				t.Fatalf("want position %s, got %v", test.pos, err)
+			}
+		})
+	}
+}
+
+// Test that all decoding errors are reported at once
+func TestDefinitionMultipleErrors(t *testing.T) {
+	_, err := parseDefinition("test.yaml", []byte(`
+groups:
+  - id: group1
+    parallel: yes please
+    tasks:
+      - id: task1
+        cmd: "true"
+        retries: many
+        unknown: true
+`))
+
+	for _, want := range []error{WorkflowErrorInvalidDefinition, WorkflowErrorInvalidRetries, WorkflowErrorUnknownField} {
+		if !errors.Is(err, want) {
+			t.Fatalf("want %v, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package workflow
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// environ returns the environment of the commands run for task of group, of
+// the skip_cmd of group if task is nil, or of the commands initializing
+// variables if both are nil.
+//
+// It contains the environment variables of the program selected by the
+// inherit_env policy of the task, the group or the workflow, whichever is the
+// most specific, `all` by default, then the workflow variables, then the env
+// of the workflow, the group and the task. Later values override earlier
+// ones.
+func (w *Workflow) environ(group *Group, task *Task) []string {
+	w.Lock()
+	inherit := w.Status.InheritEnv
+	envs := []map[string]string{w.Status.Env}
+	w.Unlock()
+
+	if group != nil {
+		inherit = cmp.Or(group.InheritEnv, inherit)
+		envs = append(envs, group.Env)
+	}
+	if task != nil {
+		inherit = cmp.Or(task.InheritEnv, inherit)
+		envs = append(envs, task.Env)
+	}
+
+	result := map[string]string{}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if inherit == nil || inherit.All || slices.Contains(inherit.Names, k) {
+			result[k] = v
+		}
+	}
+	maps.Copy(result, w.vars())
+	for _, env := range envs {
+		maps.Copy(result, env)
+	}
+
+	env := []string{}
+	for _, k := range slices.Sorted(maps.Keys(result)) {
+		env = append(env, fmt.Sprintf("%s=%s", k, result[k]))
+	}
+	return env
+}
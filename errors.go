@@ -4,14 +4,46 @@ import "fmt"
 
 // Errors definitions
 var (
-	WorkflowErrorNoGroups    = fmt.Errorf("no group definitions found")
-	WorkflowErrorInvalidVars = fmt.Errorf("invalid variables definition")
+	WorkflowErrorInvalidDefinition = fmt.Errorf("invalid definition")
+	WorkflowErrorUnknownField      = fmt.Errorf("unknown field")
+
+	WorkflowErrorNoGroups       = fmt.Errorf("no group definitions found")
+	WorkflowErrorInvalidVars    = fmt.Errorf("invalid variables definition")
+	WorkflowErrorUnknownSecret  = fmt.Errorf("unknown secret provider")
+	WorkflowErrorInvalidInput   = fmt.Errorf("invalid input")
+	WorkflowErrorInvalidEnv     = fmt.Errorf("invalid env")
+	WorkflowErrorInvalidTimeout = fmt.Errorf("invalid timeout")
+	WorkflowErrorInvalidHooks   = fmt.Errorf("invalid hooks definition")
+	WorkflowErrorHookExits      = fmt.Errorf("exits task not allowed in hooks")
 
 	WorkflowErrorGroupMissingId    = fmt.Errorf("group missing id")
 	WorkflowErrorGroupMissingTasks = fmt.Errorf("group missing tasks")
+	WorkflowErrorParallelExits     = fmt.Errorf("exits task not allowed in parallel group")
+	WorkflowErrorConcurrentExits   = fmt.Errorf("exits task not allowed in group running alongside other groups")
 
 	WorkflowErrorTaskMissingId      = fmt.Errorf("task missing id")
 	WorkflowErrorTaskMissingCommand = fmt.Errorf("task missing cmd")
+	WorkflowErrorInvalidWeight      = fmt.Errorf("invalid weight")
+	WorkflowErrorUnreachableExits   = fmt.Errorf("exits task is never run")
+	WorkflowErrorTaskInvalidArgs    = fmt.Errorf("invalid task args")
+	WorkflowErrorUnknownTaskType    = fmt.Errorf("unknown task type")
+	WorkflowErrorInvalidRetries     = fmt.Errorf("invalid retry policy")
+
+	WorkflowErrorDuplicateId         = fmt.Errorf("duplicate id")
+	WorkflowErrorUnknownDependency   = fmt.Errorf("unknown dependency")
+	WorkflowErrorDependencyCycle     = fmt.Errorf("dependency cycle")
+	WorkflowErrorInvalidDependencies = fmt.Errorf("invalid depends_on definition")
+
+	WorkflowErrorInvalidExpression = fmt.Errorf("invalid expression")
 
 	WorkflowErrorNotFinished = fmt.Errorf("workflow not finished")
+	WorkflowErrorRunning     = fmt.Errorf("workflow already running")
+	WorkflowErrorNotRunning  = fmt.Errorf("workflow not running")
+	WorkflowErrorFinished    = fmt.Errorf("workflow finished, reset it first")
+	WorkflowErrorStarted     = fmt.Errorf("workflow already started, continue it instead")
+	WorkflowErrorTimeout     = fmt.Errorf("timeout expired")
+
+	WorkflowErrorNoPrompt       = fmt.Errorf("no pending prompt")
+	WorkflowErrorUnknownCommand = fmt.Errorf("unknown command")
+	WorkflowErrorForbidden      = fmt.Errorf("permission denied")
 )
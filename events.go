@@ -0,0 +1,210 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// eventHistory is the number of events kept for clients resuming the stream.
+const eventHistory = 1024
+
+// EventType is the type of an [Event].
+type EventType string
+
+const (
+	EventSnapshot         EventType = "snapshot"          // Full status, sent to clients when they connect
+	EventWorkflowStarted  EventType = "workflow_started"  // Workflow started or continued
+	EventGroupStarted     EventType = "group_started"     // Group started
+	EventGroupSkipped     EventType = "group_skipped"     // Group skipped by skip, skip_cmd or when
+	EventGroupBlocked     EventType = "group_blocked"     // Group not run because a dependency failed
+	EventGroupFinished    EventType = "group_finished"    // Group done, with its outcome
+	EventTaskStarted      EventType = "task_started"      // Attempt of a task started
+	EventAttemptFinished  EventType = "attempt_finished"  // Attempt of a task done, with its exit code and error
+	EventTaskSkipped      EventType = "task_skipped"      // Task skipped by skip, skip_cmd or when
+	EventTaskBlocked      EventType = "task_blocked"      // Task not run because a dependency failed
+	EventTaskFinished     EventType = "task_finished"     // Task done, with its outcome
+	EventProgress         EventType = "progress"          // Task sent its progress
+	EventOutput           EventType = "output"            // Task sent a message
+	EventError            EventType = "error"             // Task sent an error
+	EventVar              EventType = "var"               // Task published a variable
+	EventPrompt           EventType = "prompt"            // Task asked a question, waiting for an answer
+	EventAnswer           EventType = "answer"            // Prompt answered, publishing a variable
+	EventWorkflowFinished EventType = "workflow_finished" // Workflow done, with its outcome
+
+	EventAck  EventType = "ack"  // Command accepted, see [Reply]
+	EventNack EventType = "nack" // Command refused, see [Reply]
+)
+
+// Event is an incremental change of the workflow status, sent to websocket
+// and server-sent events clients.
+//
+// Clients first receive an [EventSnapshot] with the full status, then the
+// events changing it, numbered by Seq. A client reconnecting with the
+// sequence number of the last event it received, as the `since` query
+// parameter or the Last-Event-ID header, receives the events it missed, or a
+// new snapshot if they are not available anymore.
+type Event struct {
+	Seq  uint64    `json:"seq"`  // Sequence number, incremented with each event
+	Type EventType `json:"type"` // Type of the event
+	Time time.Time `json:"time"`
+
+	// Hook is the hooks list the group belongs to, "onSuccess", "onFailure"
+	// or "finally", and is empty for workflow groups.
+	Hook  string `json:"hook,omitempty"`
+	Group string `json:"group,omitempty"` // Id of the group
+	Task  string `json:"task,omitempty"`  // Id of the task
+
+	Attempt  int     `json:"attempt,omitempty"`  // Attempt of the task, starting at 1
+	Progress float64 `json:"progress,omitempty"` // Progress of the task between 0 and 1
+	Message  string  `json:"message,omitempty"`  // Message, error or question sent by the task
+	Name     string  `json:"name,omitempty"`     // Name of the published or prompted variable
+	Value    string  `json:"value,omitempty"`    // Value of the published variable
+
+	ExitCode int     `json:"exitCode,omitempty"` // Exit code of the finished attempt or task
+	Error    string  `json:"error,omitempty"`    // Error of the finished attempt, task, group or workflow
+	Outcome  Outcome `json:"outcome,omitempty"`  // Outcome of the finished task, group or workflow
+
+	GroupPercent float64 `json:"groupPercent,omitempty"` // Progress of the group in percent
+	Percent      int     `json:"percent"`                // Progress of the workflow in percent
+
+	Status *Status `json:"status,omitempty"` // Full status of snapshots
+}
+
+// emit records e and sends it to clients. Caller must hold the
+// lock, which keeps events in order.
+func (w *Workflow) emit(e Event) {
+	w.Status.Seq++
+	e.Seq = w.Status.Seq
+	e.Time = time.Now()
+	e.Percent = int(w.percent())
+
+	if e.Group != "" {
+		for _, group := range w.groups(e.Hook) {
+			if group.Id == e.Group {
+				e.GroupPercent = group.Percent
+				break
+			}
+		}
+	}
+
+	w.events = append(w.events, e)
+	if len(w.events) > eventHistory {
+		w.events = slices.Delete(w.events, 0, len(w.events)-eventHistory)
+	}
+
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return
+	}
+	w.clients.broadcast(message{seq: e.Seq, data: b})
+}
+
+// groupEvent returns an event of type t for group. Caller must hold the lock.
+func (w *Workflow) groupEvent(t EventType, group *Group) Event {
+	return Event{Type: t, Hook: w.hook(group), Group: group.Id}
+}
+
+// taskEvent returns an event of type t for task of group. Caller must hold
+// the lock.
+func (w *Workflow) taskEvent(t EventType, group *Group, task *Task) Event {
+	return Event{Type: t, Hook: w.hook(group), Group: group.Id, Task: task.Id, Attempt: task.Attempt}
+}
+
+// hook returns the name of the hooks list group belongs to, or an empty
+// string for workflow groups. Caller must hold the lock.
+func (w *Workflow) hook(group *Group) string {
+	for _, name := range []string{"onSuccess", "onFailure", "finally"} {
+		if slices.Contains(w.groups(name), group) {
+			return name
+		}
+	}
+	return ""
+}
+
+// groups returns the groups of the hooks list name, or the workflow groups if
+// name is empty. Caller must hold the lock.
+func (w *Workflow) groups(name string) []*Group {
+	switch name {
+	case "onSuccess":
+		return w.Status.OnSuccess
+	case "onFailure":
+		return w.Status.OnFailure
+	case "finally":
+		return w.Status.Finally
+	}
+	return w.Status.Groups
+}
+
+// replay returns the messages to send to a client that received the events up
+// to since, or a snapshot if since is negative or the events are not
+// available anymore. Caller must hold the lock.
+func (w *Workflow) replay(since int64) ([]message, error) {
+	result := []message{}
+
+	if since >= 0 && len(w.events) > 0 && w.events[0].Seq <= uint64(since)+1 && uint64(since) <= w.Status.Seq {
+		for i := range w.events {
+			if w.events[i].Seq <= uint64(since) {
+				continue
+			}
+			b, err := json.Marshal(&w.events[i])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, message{seq: w.events[i].Seq, data: b})
+		}
+		return result, nil
+	}
+
+	w.Status.Percent = int(w.percent())
+	b, err := json.Marshal(&Event{
+		Seq:     w.Status.Seq,
+		Type:    EventSnapshot,
+		Time:    time.Now(),
+		Percent: w.Status.Percent,
+		Status:  &w.Status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(result, message{seq: w.Status.Seq, data: b}), nil
+}
+
+// parseSince returns the sequence number of the last event received by the
+// client making request r, from the Last-Event-ID header of server-sent events
+// or the since query parameter, or -1 if it has none.
+func parseSince(r *http.Request) (int64, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("since")
+	}
+	if v == "" {
+		return -1, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 63)
+	if err != nil {
+		return -1, fmt.Errorf("invalid event id %q", v)
+	}
+	return int64(n), nil
+}
+
+// outcomeOf returns the outcome of a group or task that returned err, run with
+// ctx.
+func outcomeOf(ctx context.Context, err error, warning bool) Outcome {
+	switch {
+	case err == nil && warning:
+		return OutcomeWarning
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, WorkflowErrorTimeout), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return OutcomeTimeout
+	case errors.Is(ctx.Err(), context.Canceled):
+		return OutcomeAborted
+	}
+	return OutcomeFailure
+}
@@ -0,0 +1,163 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// Test the events sent during a run
+func TestEvents(t *testing.T) {
+	wf, fn, err := New("test_data/test-events.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, start := make_socket(t, wf, fn)
+	start()
+	events := read_events(conn)
+
+	got := []string{}
+	for i, e := range events {
+		if e.Seq != uint64(i) {
+			t.Fatalf("want sequence number %d, got %d", i, e.Seq)
+		}
+
+		s := string(e.Type)
+		for _, field := range []string{e.Hook, e.Group, e.Task, e.Message, e.Name, e.Value, e.Error, string(e.Outcome)} {
+			if field != "" {
+				s += " " + field
+			}
+		}
+		if e.Progress != 0 {
+			s += fmt.Sprintf(" %g", e.Progress)
+		}
+		if e.ExitCode != 0 {
+			s += fmt.Sprintf(" exit %d", e.ExitCode)
+		}
+		if e.Type == EventAttemptFinished {
+			s += fmt.Sprintf(" attempt %d", e.Attempt)
+		}
+		got = append(got, s)
+	}
+
+	want := []string{
+		"snapshot",
+		"workflow_started",
+		"group_started group1",
+		"task_started group1 task1",
+		"progress group1 task1 0.5",
+		"output group1 task1 hello",
+		"var group1 task1 NAME value",
+		"attempt_finished group1 task1 attempt 1",
+		"task_finished group1 task1 success",
+		"task_skipped group1 task2",
+		"task_started group1 task3",
+		"error group1 task3 oops",
+		"attempt_finished group1 task3 oops exit 1 attempt 1",
+		"task_started group1 task3",
+		"error group1 task3 oops",
+		"attempt_finished group1 task3 oops exit 1 attempt 2",
+		"task_finished group1 task3 oops warning exit 1",
+		"group_finished group1 oops warning",
+		"group_skipped group2",
+		"group_started finally cleanup",
+		"task_started finally cleanup task1",
+		"attempt_finished finally cleanup task1 attempt 1",
+		"task_finished finally cleanup task1 success",
+		"group_finished finally cleanup success",
+		"workflow_finished oops warning",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("want events:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	if events[0].Status == nil || events[0].Status.Started {
+		t.Fatalf("unexpected snapshot %+v", events[0].Status)
+	}
+	if last := events[len(events)-1]; last.Percent != 100 || last.Seq != wf.Status.Seq {
+		t.Fatalf("unexpected last event %+v", last)
+	}
+}
+
+// Test clients resuming the stream from a sequence number
+func TestEventsResume(t *testing.T) {
+	wf, fn, err := New("test_data/test-events.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(fn)
+	defer s.Close()
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	// first returns the first event received when connecting with query
+	first := func(query string) *Event {
+		t.Helper()
+		conn, _, err := websocket.Dial(context.Background(), u+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.CloseNow()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		e := &Event{}
+		err = wsjson.Read(ctx, conn, e)
+		if err != nil {
+			return nil
+		}
+		return e
+	}
+
+	// Missed events are replayed
+	e := first("?since=5")
+	if e == nil || e.Seq != 6 || e.Type != EventVar {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	// Nothing was missed
+	e = first(fmt.Sprintf("?since=%d", wf.Status.Seq))
+	if e != nil {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	// Unknown sequence numbers get a snapshot
+	for _, query := range []string{"", fmt.Sprintf("?since=%d", wf.Status.Seq+1)} {
+		e = first(query)
+		if e == nil || e.Type != EventSnapshot || e.Seq != wf.Status.Seq || !e.Status.Finished {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	}
+
+	// Events of a previous run are not replayed
+	err = wf.Reset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = first("?since=5")
+	if e == nil || e.Type != EventSnapshot || e.Status.Started {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	r, err := http.Get(s.URL + "?since=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusBadRequest {
+		t.Fatalf("want status %d, got %d", http.StatusBadRequest, r.StatusCode)
+	}
+}
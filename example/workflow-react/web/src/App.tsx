@@ -6,9 +6,13 @@ function App() {
   const [status, setStatus] = useState<WorkflowStatus|undefined>(undefined)
 
   const start = () => {
-    fetch('/go/start', {
+    // A finished workflow must be reset before it runs again, this fails
+    // harmlessly otherwise
+    fetch('/go/reset', {
       method: 'POST',
-    }).then((response) => {
+    }).then(() => fetch('/go/start', {
+      method: 'POST',
+    })).then((response) => {
       if (response.ok) {
         console.log('Workflow started')
       } else {
@@ -20,7 +24,7 @@ function App() {
   }
 
   const stop = () => {
-    fetch('/go/stop', {
+    fetch('/go/abort', {
       method: 'POST',
     }).then((response) => {
       if (response.ok) {
@@ -6,8 +6,13 @@ export interface WorkflowStatus {
     currentGroup: string,
     currentTask: string,
     error: string,
+    outcome?: string,
+    vars?: Record<string,string>,
     groups: Group[],
-    osrelease: string,
+    onSuccess?: Group[],
+    onFailure?: Group[],
+    finally?: Group[],
+    seq: number,
 }
 
 export interface Group {
@@ -16,6 +21,9 @@ export interface Group {
     started: boolean
     finished: boolean
     skip: boolean
+    blocked: boolean
+    timedOut: boolean
+    warning: boolean
     lastMessage: string
     error: string|undefined
     tasks: Task[]
@@ -26,7 +34,141 @@ export interface Task {
     weight: number,
     started: boolean,
     finished: boolean,
+    skip: boolean,
+    blocked: boolean,
+    timedOut: boolean,
+    warning: boolean,
     percent: number,
+    attempt: number,
+    attempts?: Attempt[],
+    exitCode: number,
+    lastMessage: string,
+    error: string,
+    prompt?: { name: string, question: string },
+}
+
+export interface Attempt {
+    number: number,
+    exitCode: number,
+    error?: string,
+}
+
+// Command controls the workflow, see the Command type of the Go package
+export interface WorkflowCommand {
+    id?: string,
+    command: 'start'|'continue'|'abort'|'reset'|'answer',
+    inputs?: Record<string,string>,
+    group?: string,
+    task?: string,
+    name?: string,
+    value?: string,
+}
+
+// Event is an incremental change of the status, see the Event type of the
+// Go package
+export interface WorkflowEvent {
+    seq: number,
+    type: string,
+    hook?: 'onSuccess'|'onFailure'|'finally',
+    group?: string,
+    task?: string,
+    attempt?: number,
+    progress?: number,
+    message?: string,
+    name?: string,
+    value?: string,
+    exitCode?: number,
+    error?: string,
+    outcome?: string,
+    groupPercent?: number,
+    percent: number,
+    status?: WorkflowStatus,
+}
+
+// applyEvent applies event e to status, and returns the group it changed
+// if any
+export function applyEvent(status: WorkflowStatus, e: WorkflowEvent): Group|undefined {
+    status.seq = e.seq
+    status.percent = e.percent
+
+    const group = (e.hook ? status[e.hook] : status.groups)?.find((g) => g.id === e.group)
+    const task = group?.tasks.find((t) => t.id === e.task)
+    if (group && e.groupPercent !== undefined) {
+        group.percent = e.groupPercent
+    }
+
+    switch (e.type) {
+    case 'workflow_started':
+        status.started = true
+        break
+    case 'workflow_finished':
+        status.finished = true
+        status.outcome = e.outcome
+        status.error = e.error ?? status.error
+        break
+    case 'group_started':
+        group!.started = true
+        status.currentGroup = group!.id
+        break
+    case 'group_skipped':
+        group!.skip = true
+        break
+    case 'group_blocked':
+        group!.blocked = true
+        break
+    case 'group_finished':
+        group!.finished = e.outcome === 'success' || e.outcome === 'warning'
+        group!.warning = e.outcome === 'warning'
+        group!.timedOut = e.outcome === 'timeout'
+        group!.error = e.error ?? group!.error
+        break
+    case 'task_started':
+        // A new run of the task starts with its first attempt
+        if (e.attempt === 1) {
+            task!.attempts = []
+        }
+        Object.assign(task!, { started: true, attempt: e.attempt, percent: 0, error: '', timedOut: false })
+        status.currentTask = task!.id
+        break
+    case 'attempt_finished':
+        task!.attempts = [...task!.attempts ?? [], { number: e.attempt!, exitCode: e.exitCode ?? 0, error: e.error }]
+        task!.exitCode = e.exitCode ?? 0
+        task!.error = e.error ?? ''
+        break
+    case 'task_skipped':
+        task!.skip = true
+        break
+    case 'task_blocked':
+        task!.blocked = true
+        break
+    case 'task_finished':
+        task!.finished = e.outcome === 'success' || e.outcome === 'warning'
+        task!.warning = e.outcome === 'warning'
+        task!.timedOut = e.outcome === 'timeout'
+        task!.exitCode = e.exitCode ?? 0
+        task!.error = e.error ?? ''
+        break
+    case 'progress':
+        task!.percent = e.progress ?? 0
+        break
+    case 'output':
+        status.lastMessage = group!.lastMessage = task!.lastMessage = e.message ?? ''
+        break
+    case 'error':
+        status.error = group!.error = task!.error = e.message ?? ''
+        break
+    case 'var':
+        status.vars = { ...status.vars, [e.name!]: e.value ?? '' }
+        break
+    case 'prompt':
+        task!.prompt = { name: e.name!, question: e.message ?? '' }
+        break
+    case 'answer':
+        task!.prompt = undefined
+        status.vars = { ...status.vars, [e.name!]: e.value ?? '' }
+        break
+    }
+    return group
 }
 
 export class WorkflowClient {
@@ -58,38 +200,61 @@ export class WorkflowClient {
         this.onerror = callback
     }
     
+    // send sends a command, acknowledged by an ack or nack message
+    send(command: WorkflowCommand) {
+        this.websocket?.send(JSON.stringify(command))
+    }
+
     read() {
         if (this.websocket) {
             return
         }
-        this.websocket = new WebSocket(this.endpoint)
+        // Resume from the last event received, the server sends a snapshot
+        // if it can't
+        const url = this.status ? `${this.endpoint}?since=${this.status.seq}` : this.endpoint
+        this.websocket = new WebSocket(url)
 
         if (this.websocket === undefined) {
             console.error("WebSocket not ready")
             return
         }
 
-        this.websocket.onmessage = (e) => {
-            const status = JSON.parse(e.data)
+        this.websocket.onmessage = (m) => {
+            const e: WorkflowEvent = JSON.parse(m.data)
+            if (e.type === 'ack' || e.type === 'nack') {
+                return
+            }
+
+            let groups: Group[] = []
+            if (e.type === 'snapshot' && e.status) {
+                this.status = e.status
+                groups = e.status.groups
+            } else if (this.status) {
+                const group = applyEvent(this.status, e)
+                if (group) {
+                    groups = [group]
+                }
+            }
 
+            const status = this.status
             if (status) {
+                // Copy the status, so that it is seen as changed
                 if (this.onstatus) {
-                    this.onstatus(status);
+                    this.onstatus({ ...status });
                 }
 
-                for (const group of status.groups) {
+                for (const group of groups) {
                     if (this.ongroup && this.ongroup[group.id]) {
                         this.ongroup[group.id](group)
                     }
                 }
 
-                if (status.error) {
+                if (e.type === 'error' || (e.type === 'workflow_finished' && status.error)) {
                     if (this.onerror) {
                         this.onerror(status);
                     }
                 }
             }
-            this.status = status
         }
 
         this.websocket.onerror = (e) => {
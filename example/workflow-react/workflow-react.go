@@ -1,7 +1,6 @@
 package main
 
 import (
-	"log/slog"
 	"net/http"
 
 	"github.com/ybizeul/workflow"
@@ -15,22 +14,13 @@ func main() {
 
 	defer wf.Abort()
 
-	http.Handle("POST /start", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		go func() {
-			wf.Reset()
-			if err := wf.Start(); err != nil {
-				slog.Error("Workflow ended", "err", err)
-			}
-		}()
-		w.WriteHeader(http.StatusOK)
-	}))
-
-	http.Handle("POST /stop", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		wf.Abort()
-		w.WriteHeader(http.StatusOK)
-	}))
+	// POST /start, /abort, /continue, /reset and GET /status
+	http.Handle("/", wf.ControlHandler())
 
 	http.Handle("GET /wf", wfhandler)
 
+	// Same events as the websocket, for clients behind proxies
+	http.Handle("GET /events", wf.EventsHandler())
+
 	_ = http.ListenAndServe(":8080", nil)
 }
@@ -0,0 +1,322 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// An Executor runs tasks of a given `type`. Executors are registered on a
+// [Workflow] with [Workflow.RegisterExecutor].
+//
+// Two executors are built-in:
+//
+// - `shell`, the default, runs `cmd` with `/bin/bash -c`, providing the
+// `output`, `progress`, `error`, `set` and `prompt` shell functions.
+//
+// - `exec` runs the program and arguments from `args` directly without a
+// shell, or `cmd` split on white spaces if `args` is not set. The program can
+// send messages by writing lines like `output:: message` to the fifo whose
+// path is in the WFOUT environment variable, and read answers to prompts
+// from the fifo whose path is in WFIN.
+type Executor interface {
+	// Execute runs the task of e and returns when it is done. It must return
+	// when ctx is done, which happens when the workflow is aborted or a
+	// timeout expires.
+	Execute(ctx context.Context, e *Execution) error
+}
+
+// ExecutorFunc is an adapter to use ordinary functions as executors, for
+// example to run in-process Go functions as tasks.
+//
+//	wf.RegisterExecutor("download", workflow.ExecutorFunc(
+//		func(ctx context.Context, e *workflow.Execution) error {
+//			e.Output("downloading " + e.Task.With["url"].(string))
+//			...
+//			e.Progress(1)
+//			return nil
+//		}))
+type ExecutorFunc func(ctx context.Context, e *Execution) error
+
+// Execute calls f(ctx, e).
+func (f ExecutorFunc) Execute(ctx context.Context, e *Execution) error {
+	return f(ctx, e)
+}
+
+// Execution holds everything an [Executor] needs to run a task, and lets it
+// report output, progress, errors and variables through the same status
+// machinery as shell tasks.
+type Execution struct {
+	Task   *Task     // Task to run, must not be modified
+	Dir    string    // Workflow directory
+	Env    []string  // Environment, in the form "key=value", with the workflow variables and env
+	Stdout io.Writer // Standard output of the task
+	Stderr io.Writer // Standard error of the task
+
+	messages io.Writer                // Receives messages for the workflow
+	answers  <-chan string            // Answers to prompts
+	redact   func(line string) string // Masks secrets in messages written to the standard output
+	output   io.Writer                // Standard output of the program
+	lock     sync.Mutex
+}
+
+// Output sends a message to the workflow, like the `output` shell function.
+func (e *Execution) Output(message string) {
+	e.send("output", message)
+}
+
+// Progress sends the progress of the task, between 0 and 1, like the
+// `progress` shell function.
+func (e *Execution) Progress(progress float64) {
+	e.send("progress", strconv.FormatFloat(progress, 'f', -1, 64))
+}
+
+// Error sends an error description to the workflow, like the `error` shell
+// function.
+func (e *Execution) Error(message string) {
+	e.send("error", message)
+}
+
+// Set publishes a variable for subsequent tasks, like the `set` shell
+// function.
+func (e *Execution) Set(name, value string) {
+	e.send("set", name+" "+value)
+}
+
+// Prompt asks question to the users of the workflow, like the `prompt` shell
+// function, and waits for the answer given with [Workflow.Answer], which is
+// also published as variable name. It returns an error if ctx is done first.
+func (e *Execution) Prompt(ctx context.Context, name, question string) (string, error) {
+	if !varNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid variable name %q", name)
+	}
+	e.send("prompt", name+" "+question)
+	select {
+	case answer := <-e.answers:
+		return answer, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// send formats a single line message for the workflow.
+func (e *Execution) send(kind, message string) {
+	message = strings.ReplaceAll(message, "\n", " ")
+	e.write(kind + ":: " + message + "\n")
+}
+
+// write forwards a message line to the standard output and to the workflow.
+func (e *Execution) write(line string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	stdout := line
+	if e.redact != nil {
+		stdout = e.redact(line)
+	}
+	_, err := io.WriteString(e.output, stdout)
+	if err != nil {
+		slog.Error("error while writing to Stdout", "error", err)
+	}
+
+	if e.messages != nil {
+		_, err = e.messages.Write([]byte(line))
+		if err != nil {
+			slog.Error("error while writing to WFout", "error", err)
+		}
+	}
+}
+
+// shellExecutor runs tasks with bash.
+type shellExecutor struct{}
+
+func (shellExecutor) Execute(ctx context.Context, e *Execution) error {
+	return e.run(ctx, "/bin/bash", "-c", `
+	function output() {
+		[ -p "$WFOUT" ] && echo "output:: $*" > "$WFOUT"
+	}
+	function progress() {
+		[ -p "$WFOUT" ] && echo "progress:: $*" > "$WFOUT"
+	}
+	function error() {
+		[ -p "$WFOUT" ] && echo "error:: $*" > "$WFOUT"
+	}
+	function set() {
+		if [ $# -ge 1 ] && [[ "$1" =~ ^[A-Za-z_][A-Za-z0-9_]*$ ]]; then
+			printf -v "$1" '%s' "${*:2}"
+			[ -p "$WFOUT" ] && echo "set:: $*" > "$WFOUT"
+		else
+			builtin set "$@"
+		fi
+	}
+	function prompt() {
+		[[ "$1" =~ ^[A-Za-z_][A-Za-z0-9_]*$ ]] && [ -p "$WFOUT" ] && [ -p "$WFIN" ] || return 1
+		echo "prompt:: $*" > "$WFOUT"
+		IFS= read -r "$1" < "$WFIN"
+	}
+	`+e.Task.Cmd)
+}
+
+// execExecutor runs tasks programs directly, without a shell.
+type execExecutor struct{}
+
+func (execExecutor) Execute(ctx context.Context, e *Execution) error {
+	args := e.Task.Args
+	if len(args) == 0 {
+		args = strings.Fields(e.Task.Cmd)
+	}
+	if len(args) == 0 {
+		return WorkflowErrorTaskMissingCommand
+	}
+	return e.run(ctx, args[0], args[1:]...)
+}
+
+// killGroup kills the process group of cmd, started with Setpgid so that
+// the children of the command are killed along with it.
+func killGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	pgid, err := syscall.Getpgid(cmd.Process.Pid)
+	if err != nil {
+		return err
+	}
+	_ = syscall.Kill(-pgid, syscall.SIGTERM) // note the minus sign
+	return nil
+}
+
+// run runs a program for the task, in its own process group which is killed
+// when ctx is done. The program can send messages to the workflow through the
+// fifo whose path is in the WFOUT environment variable, and read answers to
+// its prompts, one per line, from the fifo whose path is in WFIN.
+func (e *Execution) run(ctx context.Context, name string, args ...string) error {
+	t := e.Task
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// Kill the whole process group when ctx is done, either because the
+	// workflow is aborted or because a timeout expired
+	cmd.Cancel = t.abort
+
+	cmd.Dir = e.Dir
+	cmd.Env = e.Env
+	cmd.Stdout = e.Stdout
+	cmd.Stderr = e.Stderr
+	t.cmd = cmd
+
+	// Create local fifo for messages
+
+	wfout_dir_path, err := os.MkdirTemp("", "workflow.*")
+	if err != nil {
+		return err
+	}
+	wfout_path := path.Join(wfout_dir_path, ".task")
+	defer os.RemoveAll(wfout_dir_path)
+	err = syscall.Mknod(wfout_path, syscall.S_IFIFO|0666, 0)
+	if err != nil {
+		return err
+	}
+
+	cmd.Env = append(cmd.Env, fmt.Sprintf("WFOUT=%s", wfout_path))
+
+	// Create local fifo for answers to prompts, opened read-write so that
+	// writing never blocks, even when the task doesn't read it
+	wfin_path := path.Join(wfout_dir_path, ".answers")
+	err = syscall.Mknod(wfin_path, syscall.S_IFIFO|0666, 0)
+	if err != nil {
+		return err
+	}
+	inputf, err := os.OpenFile(wfin_path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer inputf.Close()
+
+	cmd.Env = append(cmd.Env, fmt.Sprintf("WFIN=%s", wfin_path))
+
+	stop_answers := make(chan struct{})
+	defer close(stop_answers)
+	go func() {
+		for {
+			select {
+			case answer := <-e.answers:
+				_, err := inputf.WriteString(strings.ReplaceAll(answer, "\n", " ") + "\n")
+				if err != nil {
+					slog.Error("error while writing answer", "error", err)
+				}
+			case <-stop_answers:
+				return
+			}
+		}
+	}()
+
+	block_output := make(chan struct{})
+	block_start := make(chan struct{})
+
+	var outputf *os.File
+
+	go func() {
+		outputf, err = os.OpenFile(wfout_path, os.O_RDWR, 0)
+		if err != nil {
+			slog.Error("unable to open fifo", "error", err)
+			close(block_start)
+			return
+		}
+		close(block_start)
+		defer func() {
+			err := outputf.Close()
+			if err != nil {
+				slog.Error("error while closing fifo", "error", err)
+			}
+		}()
+
+		rd := bufio.NewReader(outputf)
+		for {
+			s, err := rd.ReadString('\n')
+			if err != nil {
+				if err.Error() == "EOF" {
+					break
+				}
+				slog.Error("error while reading fifo", "error", err)
+				break
+			}
+
+			if s == "end::\n" {
+				close(block_output)
+				break
+			}
+
+			e.write(s)
+		}
+	}()
+
+	<-block_start
+	if outputf == nil {
+		return fmt.Errorf("unable to open fifo %s", wfout_path)
+	}
+	cmdErr := cmd.Start()
+	if cmdErr != nil {
+		slog.Error("error while starting command", "error", cmdErr)
+	} else {
+		cmdErr = cmd.Wait()
+	}
+
+	_, err = outputf.WriteString("end::\n")
+	if err != nil {
+		return err
+	}
+
+	<-block_output
+
+	return cmdErr
+}
@@ -0,0 +1,431 @@
+package workflow
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ExprError is returned when a `when` expression can't be parsed or
+// evaluated. It wraps [WorkflowErrorInvalidExpression].
+type ExprError struct {
+	Expr string // Source of the expression
+	Pos  int    // Position of the error in Expr, in bytes
+	Msg  string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("%s: %s at position %d in %q", WorkflowErrorInvalidExpression, e.Msg, e.Pos, e.Expr)
+}
+
+func (e *ExprError) Unwrap() error { return WorkflowErrorInvalidExpression }
+
+// resolver returns the value of a reference like `OS` or `tasks.check.error`
+// split on dots.
+type resolver func(path []string) (any, error)
+
+// expr is a compiled `when` expression.
+//
+// Expressions support string literals in single or double quotes, numbers,
+// `true` and `false`, references to variables (`OS`) or to the state of
+// groups and tasks (`tasks.check.exit_code`), comparison operators `==`, `!=`,
+// `<`, `<=`, `>`, `>=`, logical operators `&&`, `||` and `!`, and
+// parentheses.
+type expr struct {
+	src  string
+	root exprNode
+}
+
+// compileExpr parses src and returns the compiled expression.
+func compileExpr(src string) (*expr, error) {
+	p := &parser{src: src}
+	err := p.tokenize()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+
+	return &expr{src: src, root: root}, nil
+}
+
+// eval evaluates the expression, resolving references with r, and returns
+// its truth value.
+func (e *expr) eval(r resolver) (bool, error) {
+	v, err := e.root.eval(r)
+	if err != nil {
+		return false, &ExprError{Expr: e.src, Msg: err.Error()}
+	}
+	return truthy(v), nil
+}
+
+type exprNode interface {
+	eval(r resolver) (any, error)
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n *literalNode) eval(r resolver) (any, error) { return n.value, nil }
+
+type referenceNode struct {
+	path []string
+}
+
+func (n *referenceNode) eval(r resolver) (any, error) { return r(n.path) }
+
+type notNode struct {
+	x exprNode
+}
+
+func (n *notNode) eval(r resolver) (any, error) {
+	v, err := n.x.eval(r)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type binaryNode struct {
+	op   string
+	x, y exprNode
+}
+
+func (n *binaryNode) eval(r resolver) (any, error) {
+	x, err := n.x.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit logical operators
+	switch n.op {
+	case "&&":
+		if !truthy(x) {
+			return false, nil
+		}
+		y, err := n.y.eval(r)
+		return truthy(y), err
+	case "||":
+		if truthy(x) {
+			return true, nil
+		}
+		y, err := n.y.eval(r)
+		return truthy(y), err
+	}
+
+	y, err := n.y.eval(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := compare(x, y)
+	switch n.op {
+	case "==":
+		return ok && c == 0, nil
+	case "!=":
+		return !ok || c != 0, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("can't compare %v %s %v", x, n.op, y)
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+// compare returns -1, 0 or 1 comparing x and y. Values are compared as
+// numbers if both can be converted to numbers, as booleans if both are
+// booleans, and as strings otherwise. It returns false if they can't be
+// compared.
+func compare(x, y any) (int, bool) {
+	if a, ok := number(x); ok {
+		if b, ok := number(y); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	a, aBool := x.(bool)
+	b, bBool := y.(bool)
+	if aBool || bBool {
+		if aBool && bBool && a == b {
+			return 0, true
+		}
+		return 1, aBool && bBool
+	}
+
+	return strings.Compare(fmt.Sprint(x), fmt.Sprint(y)), true
+}
+
+// number converts v to a float64 if it is a number or a string containing a
+// number.
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// truthy returns the truth value of v. False, zero, empty strings and the
+// strings "0" and "false" are false, anything else is true.
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	case string:
+		return v != "" && v != "0" && v != "false"
+	}
+	return v != nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	next   int
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &ExprError{Expr: p.src, Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// tokenize splits the source into tokens.
+func (p *parser) tokenize() error {
+	src := p.src
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			b := strings.Builder{}
+			i++
+			for i < len(src) && rune(src[i]) != c {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			if i >= len(src) {
+				return &ExprError{Expr: src, Pos: start, Msg: "unterminated string"}
+			}
+			i++
+			p.tokens = append(p.tokens, token{kind: tokenString, text: b.String(), pos: start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			p.tokens = append(p.tokens, token{kind: tokenNumber, text: src[start:i], pos: start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] == '.' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			p.tokens = append(p.tokens, token{kind: tokenIdent, text: src[start:i], pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "-"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return &ExprError{Expr: src, Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			p.tokens = append(p.tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokenEOF, pos: len(src)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) accept(ops ...string) (token, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return t, false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next++
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (p *parser) parseOr() (exprNode, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.accept("||")
+		if !ok {
+			return x, nil
+		}
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: t.text, x: x, y: y}
+	}
+}
+
+func (p *parser) parseAnd() (exprNode, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.accept("&&")
+		if !ok {
+			return x, nil
+		}
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: t.text, x: x, y: y}
+	}
+}
+
+func (p *parser) parseNot() (exprNode, error) {
+	if _, ok := p.accept("!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (exprNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := p.accept("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return x, nil
+	}
+	y, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: t.text, x: x, y: y}, nil
+}
+
+func (p *parser) parsePrimary() (exprNode, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenString:
+		p.next++
+		return &literalNode{value: t.text}, nil
+	case tokenNumber:
+		p.next++
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %q", t.text)
+		}
+		return &literalNode{value: f}, nil
+	case tokenIdent:
+		p.next++
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		}
+		path := strings.Split(t.text, ".")
+		if slices.Contains(path, "") {
+			return nil, p.errorf(t, "invalid reference %q", t.text)
+		}
+		return &referenceNode{path: path}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			p.next++
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, p.errorf(p.peek(), "missing closing parenthesis")
+			}
+			return x, nil
+		case "-":
+			p.next++
+			n := p.peek()
+			if n.kind != tokenNumber {
+				return nil, p.errorf(n, "expected a number after -")
+			}
+			p.next++
+			f, err := strconv.ParseFloat(n.text, 64)
+			if err != nil {
+				return nil, p.errorf(n, "invalid number %q", n.text)
+			}
+			return &literalNode{value: -f}, nil
+		}
+	case tokenEOF:
+		return nil, p.errorf(t, "unexpected end of expression")
+	}
+	return nil, p.errorf(t, "unexpected %q", t.text)
+}
@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	vars := map[string]any{
+		"OS":                    "Linux",
+		"COUNT":                 "12",
+		"vars.EMPTY":            "",
+		"tasks.check.exit_code": 0,
+		"tasks.check.error":     "",
+		"tasks.check.finished":  true,
+	}
+	r := func(path []string) (any, error) {
+		key := strings.Join(path, ".")
+		v, ok := vars[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown reference %s", key)
+		}
+		return v, nil
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`OS == "Linux"`, true},
+		{`OS != 'Linux'`, false},
+		{`OS == "Linux" && tasks.check.exit_code == 0`, true},
+		{`OS == "Darwin" || tasks.check.finished`, true},
+		{`!tasks.check.finished`, false},
+		{`!(OS == "Darwin")`, true},
+		{`COUNT > 9`, true},
+		{`COUNT <= -1`, false},
+		{`vars.EMPTY`, false},
+		{`tasks.check.error == ""`, true},
+		{`true && !false`, true},
+		{`OS == "Darwin" && unknown`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			e, err := compileExpr(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := e.eval(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+	}{
+		{`OS ==`, 5},
+		{`OS == "Linux`, 6},
+		{`(OS == "Linux"`, 14},
+		{`OS = "Linux"`, 3},
+		{`OS == "Linux" "Darwin"`, 14},
+		{`tasks..check`, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := compileExpr(test.expr)
+			var exprErr *ExprError
+			if !errors.As(err, &exprErr) || !errors.Is(err, WorkflowErrorInvalidExpression) {
+				t.Fatalf("want an ExprError, got %v", err)
+			}
+			if exprErr.Pos != test.pos {
+				t.Fatalf("want position %d, got %d (%v)", test.pos, exprErr.Pos, err)
+			}
+		})
+	}
+}
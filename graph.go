@@ -0,0 +1,263 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DependencyError is returned when dependencies declared with `depends_on`
+// can't be satisfied. It wraps either [WorkflowErrorUnknownDependency],
+// [WorkflowErrorDependencyCycle] or [WorkflowErrorDuplicateId].
+type DependencyError struct {
+	Id         string // Id of the group or task declaring the dependency
+	Dependency string // Offending dependency
+	Err        error
+}
+
+func (e *DependencyError) Error() string {
+	if e.Dependency == "" {
+		return fmt.Sprintf("%s: %s", e.Id, e.Err)
+	}
+	return fmt.Sprintf("%s depends on %s: %s", e.Id, e.Dependency, e.Err)
+}
+
+func (e *DependencyError) Unwrap() error { return e.Err }
+
+// graph holds the dependencies between sibling nodes of a workflow, which are
+// either the groups of the workflow or the tasks of a group. Nodes are
+// referenced by their position in the definition.
+type graph struct {
+	ids  []string
+	deps [][]int
+}
+
+// newGraph returns the dependency graph for nodes ids, where dependsOn holds
+// the `depends_on` declaration of each node.
+//
+// A node without a declaration (nil) depends on the previous node if
+// sequential is true, and on nothing otherwise, so that workflows not using
+// `depends_on` run in list order.
+func newGraph(ids []string, dependsOn [][]string, sequential bool) (*graph, error) {
+	index := map[string]int{}
+	for i, id := range ids {
+		if _, ok := index[id]; ok {
+			return nil, &DependencyError{Id: id, Err: WorkflowErrorDuplicateId}
+		}
+		index[id] = i
+	}
+
+	result := &graph{
+		ids:  ids,
+		deps: make([][]int, len(ids)),
+	}
+
+	for i := range ids {
+		if dependsOn[i] == nil {
+			if sequential && i > 0 {
+				result.deps[i] = []int{i - 1}
+			}
+			continue
+		}
+		for _, dep := range dependsOn[i] {
+			j, ok := index[dep]
+			if !ok {
+				return nil, &DependencyError{Id: ids[i], Dependency: dep, Err: WorkflowErrorUnknownDependency}
+			}
+			result.deps[i] = append(result.deps[i], j)
+		}
+	}
+
+	err := result.checkCycles()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// checkCycles returns a [DependencyError] if the graph contains a cycle.
+func (g *graph) checkCycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(g.ids))
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		state[i] = visiting
+		for _, j := range g.deps[i] {
+			switch state[j] {
+			case visiting:
+				return &DependencyError{Id: g.ids[i], Dependency: g.ids[j], Err: WorkflowErrorDependencyCycle}
+			case unvisited:
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+
+	for i := range g.ids {
+		if state[i] == unvisited {
+			if err := visit(i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// concurrent returns true if node i can run at the same time as another
+// node, one that it doesn't depend on and that doesn't depend on it.
+func (g *graph) concurrent(i int) bool {
+	for j := range g.ids {
+		if j != i && !g.dependsOn(i, j) && !g.dependsOn(j, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// dependsOn returns true if node i depends on node j, directly or not.
+func (g *graph) dependsOn(i, j int) bool {
+	seen := make([]bool, len(g.ids))
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		for _, k := range g.deps[i] {
+			if k == j {
+				return true
+			}
+			if !seen[k] {
+				seen[k] = true
+				if visit(k) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return visit(i)
+}
+
+// order returns the nodes sorted so that each node comes after its
+// dependencies, keeping their declaration order otherwise.
+func (g *graph) order() []int {
+	result := []int{}
+	state := make([]nodeState, len(g.ids))
+	for len(result) < len(g.ids) {
+		for i := range state {
+			if state[i] == nodePending && g.ready(i, state) {
+				state[i] = nodeSucceeded
+				result = append(result, i)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// nodeState is the state of a node while the graph is scheduled.
+type nodeState int
+
+const (
+	nodePending nodeState = iota
+	nodeRunning
+	nodeSucceeded
+	nodeFailed
+	nodeBlocked
+)
+
+// schedule runs the nodes of the graph with run, starting each node as soon
+// as all its dependencies succeeded, with at most limit nodes running at the
+// same time.
+//
+// Nodes for which done returns true, typically finished during a previous
+// run, are considered successful and not run again. Nodes that can't run
+// because one of their dependencies failed are reported to blocked, except
+// when ctx is done.
+//
+// No new node is started once ctx is done, and schedule returns after all
+// running nodes returned, with the errors they returned joined.
+func (g *graph) schedule(ctx context.Context, limit int, done func(i int) bool, run func(i int) error, blocked func(i int)) error {
+	type result struct {
+		node int
+		err  error
+	}
+
+	state := make([]nodeState, len(g.ids))
+	for i := range state {
+		if done(i) {
+			state[i] = nodeSucceeded
+		}
+	}
+
+	results := make(chan result)
+	running := 0
+	errs := []error{}
+
+	for {
+		// Propagate failures to downstream nodes, unless execution is being
+		// stopped
+		for changed := ctx.Err() == nil; changed; {
+			changed = false
+			for i := range state {
+				if state[i] != nodePending {
+					continue
+				}
+				for _, j := range g.deps[i] {
+					if state[j] == nodeFailed || state[j] == nodeBlocked {
+						state[i] = nodeBlocked
+						blocked(i)
+						changed = true
+						break
+					}
+				}
+			}
+		}
+
+		// Start nodes whose dependencies all succeeded
+		for i := range state {
+			if ctx.Err() != nil || running >= limit {
+				break
+			}
+			if state[i] != nodePending || !g.ready(i, state) {
+				continue
+			}
+			state[i] = nodeRunning
+			running++
+			go func() {
+				results <- result{node: i, err: run(i)}
+			}()
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+		if r.err != nil {
+			state[r.node] = nodeFailed
+			errs = append(errs, r.err)
+		} else {
+			state[r.node] = nodeSucceeded
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ready returns true if all dependencies of node i succeeded.
+func (g *graph) ready(i int, state []nodeState) bool {
+	for _, j := range g.deps[i] {
+		if state[j] != nodeSucceeded {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestGraphErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		ids       []string
+		dependsOn [][]string
+		want      error
+	}{
+		{"duplicate", []string{"a", "a"}, [][]string{nil, nil}, WorkflowErrorDuplicateId},
+		{"unknown", []string{"a", "b"}, [][]string{nil, {"c"}}, WorkflowErrorUnknownDependency},
+		{"cycle", []string{"a", "b", "c"}, [][]string{{"c"}, {"a"}, {"b"}}, WorkflowErrorDependencyCycle},
+		{"self", []string{"a"}, [][]string{{"a"}}, WorkflowErrorDependencyCycle},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newGraph(test.ids, test.dependsOn, true)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("want %v, got %v", test.want, err)
+			}
+			var depErr *DependencyError
+			if !errors.As(err, &depErr) {
+				t.Fatalf("want a DependencyError, got %T", err)
+			}
+		})
+	}
+}
+
+func TestGraphSchedule(t *testing.T) {
+	// b and c depend on a, d depends on b and c, e depends on nothing
+	g, err := newGraph(
+		[]string{"a", "b", "c", "d", "e"},
+		[][]string{nil, {"a"}, {"a"}, {"b", "c"}, {}},
+		true,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := errors.New("failed")
+
+	order := []string{}
+	blocked := []string{}
+	lock := sync.Mutex{}
+
+	err = g.schedule(context.Background(), 2,
+		func(i int) bool { return false },
+		func(i int) error {
+			lock.Lock()
+			defer lock.Unlock()
+			order = append(order, g.ids[i])
+			if g.ids[i] == "c" {
+				return failing
+			}
+			return nil
+		},
+		func(i int) {
+			blocked = append(blocked, g.ids[i])
+		},
+	)
+
+	if !errors.Is(err, failing) {
+		t.Fatalf("want %v, got %v", failing, err)
+	}
+
+	if slices.Index(order, "a") > slices.Index(order, "b") || slices.Index(order, "a") > slices.Index(order, "c") {
+		t.Fatalf("dependencies not honored %v", order)
+	}
+
+	if slices.Contains(order, "d") || !slices.Equal(blocked, []string{"d"}) {
+		t.Fatalf("d should be blocked, ran %v, blocked %v", order, blocked)
+	}
+
+	if !slices.Contains(order, "e") {
+		t.Fatalf("e should have run %v", order)
+	}
+}
+
+func TestGraphOrder(t *testing.T) {
+	// a depends on c, declaration order is kept otherwise
+	g, err := newGraph(
+		[]string{"a", "b", "c", "d"},
+		[][]string{{"c"}, nil, {"d"}, nil},
+		false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := []string{}
+	for _, i := range g.order() {
+		order = append(order, g.ids[i])
+	}
+	if want := []string{"b", "d", "c", "a"}; !slices.Equal(order, want) {
+		t.Fatalf("want %v, got %v", want, order)
+	}
+}
+
+func TestGraphConcurrent(t *testing.T) {
+	// b and c depend on a, d depends on b, so only a runs alone
+	g, err := newGraph(
+		[]string{"a", "b", "c", "d"},
+		[][]string{nil, {"a"}, {"a"}, {"b"}},
+		true,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []bool{false, true, true, true}
+	for i := range g.ids {
+		if got := g.concurrent(i); got != want[i] {
+			t.Errorf("%s: want %v, got %v", g.ids[i], want[i], got)
+		}
+	}
+
+	// Sequential nodes never run at the same time
+	g, err = newGraph([]string{"a", "b", "c"}, [][]string{nil, nil, nil}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range g.ids {
+		if g.concurrent(i) {
+			t.Errorf("%s: want false, got true", g.ids[i])
+		}
+	}
+}
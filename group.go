@@ -3,41 +3,85 @@ package workflow
 // Group represents a group of tasks in a workflow. It will give informations
 // about the execution state like completion percentage, any error that occurred
 // and the last message received from the tasks.
-// Groups can be skipped if the command in skip_cmd from the yaml definition
-// returns a zero status code.
+// Groups can be skipped if `skip` is set to true, or if the command in skip_cmd
+// from the yaml definition returns a zero status code. skip_cmd is run right
+// before the group would start, so it can use variables published by previous
+// tasks.
+//
+// Groups can also declare a `when` expression, evaluated in-process right
+// before the group would start, and are skipped if it is false. Expressions
+// can compare variables and the state of previous tasks and groups, like:
+//
+//	OS == "Linux" && tasks.check.exit_code == 0
+//
+// Supported operators are `==`, `!=`, `<`, `<=`, `>`, `>=`, `&&`, `||`, `!`
+// and parentheses. References are either a variable name, optionally prefixed
+// with `vars.`, or `tasks.<id>.<field>` and `groups.<id>.<field>` where field
+// is one of `started`, `finished`, `skipped`, `blocked`, `warning`,
+// `timed_out` or `error`, plus `exit_code` and `attempt` for tasks. Tasks are
+// looked up in the current group first. Syntax errors are reported when the
+// workflow is loaded.
+//
+// When `parallel` is set to true, tasks of the group are run concurrently,
+// with at most `max_concurrency` tasks running at the same time if it is set
+// to a positive value. The group is finished once all of its tasks are done.
+//
+// A group running longer than `timeout` has its running tasks killed and fails
+// with [WorkflowErrorTimeout].
+//
+// When `continue_on_error` is set to true, all tasks of the group are allowed
+// to fail, and the group is flagged with a `Warning` if any of them does.
+//
+// A group can declare the ids of groups it depends on with `depends_on`. It
+// will only start once all of them succeeded, and will be marked as `Blocked`
+// if one of them fails. Groups without `depends_on` depend on the previous
+// group of the workflow.
 type Group struct {
-	Id          string  `json:"id"`
-	Tasks       []*Task `json:"tasks"`
-	skip_cmd    string
-	Skip        bool    `json:"skip"`
-	Percent     float64 `json:"percent"`
-	Started     bool    `json:"started"`
-	Finished    bool    `json:"finished"`
-	LastMessage string  `json:"lastMessage"`
-	Error       string  `json:"error"`
+	Id              string            `json:"id"`
+	Tasks           []*Task           `json:"tasks"`
+	SkipCmd         string            `json:"skipCmd,omitempty"`
+	When            string            `json:"when,omitempty"`
+	Parallel        bool              `json:"parallel"`
+	MaxConcurrency  int               `json:"maxConcurrency,omitempty"`
+	DependsOn       []string          `json:"dependsOn,omitempty"`
+	Timeout         Duration          `json:"timeout,omitempty"`
+	ContinueOnError bool              `json:"continueOnError,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	InheritEnv      *InheritEnv       `json:"inheritEnv,omitempty"`
+	Skip            bool              `json:"skip"`
+	Percent         float64           `json:"percent"`
+	Started         bool              `json:"started"`
+	Finished        bool              `json:"finished"`
+	LastMessage     string            `json:"lastMessage"`
+	Error           string            `json:"error"`
+	Blocked         bool              `json:"blocked"` // A dependency failed
+	TimedOut        bool              `json:"timedOut"`
+	Warning         bool              `json:"warning"` // A task failed but was allowed to
 }
 
-func newGroup(y map[string]any) (*Group, error) {
-	id, ok := y["id"].(string)
-	if !ok {
-		return nil, WorkflowErrorGroupMissingId
-	}
-
-	skip_cmd, _ := y["skip_cmd"].(string)
-
-	tasks, ok := y["tasks"].([]any)
-	if !ok {
-		return nil, WorkflowErrorGroupMissingTasks
+func newGroup(def *GroupDefinition) (*Group, error) {
+	if errs := def.check(); len(errs) > 0 {
+		return nil, errs[0]
 	}
 
 	result := &Group{
-		Id:       id,
-		Tasks:    []*Task{},
-		skip_cmd: skip_cmd,
+		Id:             def.Id,
+		Tasks:          []*Task{},
+		SkipCmd:        def.SkipCmd,
+		Skip:           def.Skip,
+		When:           def.When,
+		Parallel:       def.Parallel,
+		MaxConcurrency: def.MaxConcurrency,
+		DependsOn:      def.DependsOn,
+		Timeout:        def.Timeout,
+
+		ContinueOnError: def.ContinueOnError,
+		Env:             def.Env,
+		InheritEnv:      def.InheritEnv,
 	}
 
-	for i := range tasks {
-		task, err := newTask(tasks[i].(map[string]any))
+	for i := range def.Tasks {
+		task, err := newTask(def.Tasks[i])
 		if err != nil {
 			return nil, err
 		}
@@ -47,6 +91,29 @@ func newGroup(y map[string]any) (*Group, error) {
 	return result, nil
 }
 
+// graph returns the dependency graph of the tasks of the group.
+func (w *Group) graph() (*graph, error) {
+	ids := []string{}
+	dependsOn := [][]string{}
+	for _, task := range w.Tasks {
+		ids = append(ids, task.Id)
+		dependsOn = append(dependsOn, task.DependsOn)
+	}
+	return newGraph(ids, dependsOn, !w.Parallel)
+}
+
+// concurrency returns the maximum number of tasks of the group that can run
+// at the same time.
+func (w *Group) concurrency() int {
+	if !w.Parallel {
+		return 1
+	}
+	if w.MaxConcurrency > 0 {
+		return w.MaxConcurrency
+	}
+	return max(len(w.Tasks), 1)
+}
+
 func (w *Group) progress() (float64, float64) {
 	// Calculate total weight
 	total := 0.0
@@ -54,6 +121,10 @@ func (w *Group) progress() (float64, float64) {
 	finished := true
 	for i := range w.Tasks {
 		task := w.Tasks[i]
+		// Skipped tasks are not accounted for
+		if task.Skip {
+			continue
+		}
 		if task.Finished {
 			current += float64(task.Weight)
 		} else {
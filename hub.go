@@ -0,0 +1,177 @@
+package workflow
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	hubQueueSize    = 64               // Messages queued per client before it is dropped
+	hubWriteTimeout = 10 * time.Second // Maximum duration of a write to a client
+)
+
+// hub broadcasts messages to websocket and server-sent events clients. Each
+// client has a buffered queue written to its connection by its own goroutine,
+// so that a slow client never blocks the workflow. Clients whose queue is
+// full, or that don't accept a message within the write timeout, are dropped.
+type hub struct {
+	clients      map[*client]struct{}
+	queueSize    int
+	writeTimeout time.Duration
+
+	sync.Mutex
+}
+
+// message is a message broadcast by a hub.
+type message struct {
+	seq  uint64 // Sequence number of the event
+	data []byte // JSON payload
+}
+
+// sink is the connection of a hub client.
+type sink interface {
+	// write sends m, giving up when ctx is done.
+	write(ctx context.Context, m message) error
+	// close closes the connection with code and reason.
+	close(code websocket.StatusCode, reason string)
+}
+
+// client is a connection registered in a hub.
+type client struct {
+	sink sink
+	send chan message  // Queued messages, closed when the client is removed
+	done chan struct{} // Closed once the connection is closed
+
+	// Close status sent to the client once its queue is flushed
+	code   websocket.StatusCode
+	reason string
+}
+
+// newHub returns an empty hub.
+func newHub(queueSize int, writeTimeout time.Duration) *hub {
+	return &hub{
+		clients:      map[*client]struct{}{},
+		queueSize:    queueSize,
+		writeTimeout: writeTimeout,
+	}
+}
+
+// add registers s and queues the initial messages for it.
+func (h *hub) add(s sink, messages ...message) *client {
+	c := &client{
+		sink: s,
+		send: make(chan message, max(h.queueSize, len(messages))),
+		done: make(chan struct{}),
+	}
+	for _, m := range messages {
+		c.send <- m
+	}
+
+	h.Lock()
+	h.clients[c] = struct{}{}
+	h.Unlock()
+
+	go h.write(c)
+	return c
+}
+
+// remove unregisters c. Its connection is closed with code and reason once
+// the messages already queued are sent.
+func (h *hub) remove(c *client, code websocket.StatusCode, reason string) {
+	h.Lock()
+	defer h.Unlock()
+	h.removeLocked(c, code, reason)
+}
+
+func (h *hub) removeLocked(c *client, code websocket.StatusCode, reason string) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	c.code, c.reason = code, reason
+	close(c.send)
+}
+
+// broadcast queues m for all clients, dropping the ones whose queue is full.
+func (h *hub) broadcast(m message) {
+	h.Lock()
+	defer h.Unlock()
+	for c := range h.clients {
+		select {
+		case c.send <- m:
+		default:
+			slog.Warn("dropping slow client")
+			h.removeLocked(c, websocket.StatusPolicyViolation, "client too slow")
+		}
+	}
+}
+
+// send queues m for c only, dropping c if its queue is full.
+func (h *hub) send(c *client, m message) {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	select {
+	case c.send <- m:
+	default:
+		slog.Warn("dropping slow client")
+		h.removeLocked(c, websocket.StatusPolicyViolation, "client too slow")
+	}
+}
+
+// closeAll removes all clients, closing their connection normally once their
+// queue is flushed.
+func (h *hub) closeAll() {
+	h.Lock()
+	defer h.Unlock()
+	for c := range h.clients {
+		h.removeLocked(c, websocket.StatusNormalClosure, "")
+	}
+}
+
+// len returns the number of registered clients.
+func (h *hub) len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.clients)
+}
+
+// write sends the queued messages of c until it is removed, and closes its
+// connection.
+func (h *hub) write(c *client) {
+	defer close(c.done)
+
+	for m := range c.send {
+		ctx, cancel := context.WithTimeout(context.Background(), h.writeTimeout)
+		err := c.sink.write(ctx, m)
+		cancel()
+		if err != nil {
+			slog.Warn("dropping client", "error", err)
+			h.remove(c, websocket.StatusPolicyViolation, "write failed")
+			c.sink.close(websocket.StatusPolicyViolation, "write failed")
+			return
+		}
+	}
+
+	c.sink.close(c.code, c.reason)
+}
+
+// websocketSink is a websocket hub client.
+type websocketSink struct {
+	conn *websocket.Conn
+}
+
+func (s websocketSink) write(ctx context.Context, m message) error {
+	return s.conn.Write(ctx, websocket.MessageText, m.data)
+}
+
+func (s websocketSink) close(code websocket.StatusCode, reason string) {
+	// The client may be gone already
+	_ = s.conn.Close(code, reason)
+}
@@ -0,0 +1,201 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// make_hub returns a hub served by a test server, and the websocket URL to
+// connect to it.
+func make_hub(t *testing.T, queueSize int, writeTimeout time.Duration) (*hub, string) {
+	h := newHub(queueSize, writeTimeout)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		ctx := conn.CloseRead(r.Context())
+		c := h.add(websocketSink{conn}, message{data: []byte("hello")})
+		<-ctx.Done()
+		h.remove(c, websocket.StatusNormalClosure, "")
+		<-c.done
+	}))
+	t.Cleanup(s.Close)
+
+	return h, "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// waitClients waits for h to have n clients.
+func waitClients(t *testing.T, h *hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for h.len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d clients, got %d", n, h.len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Test clients connecting and disconnecting while messages are broadcast,
+// meant to be run with the race detector
+func TestHubConcurrent(t *testing.T) {
+	h, u := make_hub(t, hubQueueSize, time.Second)
+
+	stop := make(chan struct{})
+	broadcasting := sync.WaitGroup{}
+	broadcasting.Add(1)
+	go func() {
+		defer broadcasting.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.broadcast(message{data: []byte(fmt.Sprint(i))})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	clients := sync.WaitGroup{}
+	for range 20 {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			conn, _, err := websocket.Dial(context.Background(), u, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, b, err := conn.Read(context.Background())
+			if err != nil || string(b) != "hello" {
+				t.Errorf("want hello, got %q (%v)", b, err)
+			}
+			for range 10 {
+				if _, _, err := conn.Read(context.Background()); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			conn.Close(websocket.StatusNormalClosure, "")
+		}()
+	}
+	clients.Wait()
+	close(stop)
+	broadcasting.Wait()
+
+	waitClients(t, h, 0)
+}
+
+// Test that closing the hub flushes the queued messages before closing
+// connections normally
+func TestHubCloseAll(t *testing.T) {
+	h, u := make_hub(t, hubQueueSize, time.Second)
+
+	conn, _, err := websocket.Dial(context.Background(), u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitClients(t, h, 1)
+
+	h.broadcast(message{data: []byte("1")})
+	h.broadcast(message{data: []byte("2")})
+	h.closeAll()
+
+	got := []string{}
+	for {
+		_, b, err := conn.Read(context.Background())
+		if err != nil {
+			if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+				t.Fatalf("want normal closure, got %v", err)
+			}
+			break
+		}
+		got = append(got, string(b))
+	}
+
+	want := "hello 1 2"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("want %q, got %q", want, strings.Join(got, " "))
+	}
+	if h.len() != 0 {
+		t.Fatalf("want no clients, got %d", h.len())
+	}
+}
+
+// Test that a client not reading its messages is dropped without blocking
+// the other clients
+func TestHubStalledClient(t *testing.T) {
+	h, u := make_hub(t, 4, 100*time.Millisecond)
+
+	// Never reads
+	stalled, _, err := websocket.Dial(context.Background(), u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.CloseNow()
+	stalled.SetReadLimit(-1)
+	waitClients(t, h, 1)
+
+	reader, _, err := websocket.Dial(context.Background(), u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.CloseNow()
+	reader.SetReadLimit(-1)
+	waitClients(t, h, 2)
+
+	// Large messages fill the network buffers of the stalled client
+	b := bytes.Repeat([]byte("x"), 1<<20)
+	received := make(chan error)
+	go func() {
+		for range 20 {
+			if _, _, err := reader.Read(context.Background()); err != nil {
+				received <- err
+				return
+			}
+		}
+		received <- nil
+	}()
+
+	for i := range 20 {
+		start := time.Now()
+		h.broadcast(message{data: b})
+		if time.Since(start) > 50*time.Millisecond {
+			t.Fatalf("broadcast %d blocked", i)
+		}
+		// Let the reader keep up
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The initial message and 19 broadcasts
+	if err := <-received; err != nil {
+		t.Fatal(err)
+	}
+	waitClients(t, h, 1)
+
+	// The stalled client is disconnected
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		_, _, err := stalled.Read(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				t.Fatal("stalled client not disconnected")
+			}
+			break
+		}
+	}
+}
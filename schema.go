@@ -0,0 +1,189 @@
+package workflow
+
+import (
+	_ "embed"
+	"encoding/json"
+	"reflect"
+)
+
+//go:generate sh -c "go run ./cmd/workflow schema > workflow.schema.json"
+
+// Schema is the JSON Schema of workflow definition files, embedded from
+// workflow.schema.json. It is identical to the output of [JSONSchema], and
+// can be used by editors to complete and validate definitions.
+//
+//go:embed workflow.schema.json
+var Schema string
+
+// schemaDescriptions describes the fields of definitions in the schema, by
+// type and yaml name.
+var schemaDescriptions = map[string]string{
+	"Definition.vars": "Variables, with the command whose output is their initial value",
+
+	"VarDefinition.description": "Description of the variable, for input forms",
+	"VarDefinition.type":        "Type of the value: string, int, bool or enum",
+	"VarDefinition.values":      "Allowed values of enum variables",
+	"VarDefinition.value":       "Literal initial value of the variable",
+	"VarDefinition.cmd":         "Shell command whose output is the initial value of the variable",
+	"VarDefinition.from":        "Secret provider and reference of the value, like env:TOKEN or file:/run/secrets/token",
+	"VarDefinition.default":     "Value of the input when it is not given when starting the workflow",
+	"VarDefinition.required":    "Fail to start the workflow when the input is not given",
+	"VarDefinition.secret":      "Mask the value outside of task environments",
+	"Definition.env":            "Environment variables of the commands of the workflow",
+	"Definition.inherit_env":    "Environment variables of the program inherited by commands: all, none or a list of names",
+	"Definition.timeout":        "Maximum duration of the workflow run, like \"1h\" or a number of seconds",
+	"Definition.groups":         "Groups of tasks run by the workflow",
+	"Definition.on_success":     "Groups run after all groups succeeded",
+	"Definition.on_failure":     "Groups run after a group failed, timed out or the workflow was aborted",
+	"Definition.finally":        "Groups run after the workflow groups and the other hooks, in any case",
+
+	"GroupDefinition.id":                "Unique id of the group",
+	"GroupDefinition.skip":              "Skip the group",
+	"GroupDefinition.skip_cmd":          "Shell command skipping the group when it returns a zero status code",
+	"GroupDefinition.when":              "Expression skipping the group when it is false, like OS == \"Linux\"",
+	"GroupDefinition.parallel":          "Run the tasks of the group concurrently",
+	"GroupDefinition.max_concurrency":   "Maximum number of tasks running at the same time in a parallel group",
+	"GroupDefinition.depends_on":        "Ids of the groups that must succeed before this group starts",
+	"GroupDefinition.timeout":           "Maximum duration of the group, like \"10m\" or a number of seconds",
+	"GroupDefinition.continue_on_error": "Allow all tasks of the group to fail",
+	"GroupDefinition.env":               "Environment variables of the commands of the group",
+	"GroupDefinition.inherit_env":       "Environment variables of the program inherited by commands: all, none or a list of names",
+	"GroupDefinition.tasks":             "Tasks of the group",
+
+	"TaskDefinition.id":            "Id of the task, unique in its group",
+	"TaskDefinition.type":          "Executor running the task, shell by default",
+	"TaskDefinition.cmd":           "Shell script of the task",
+	"TaskDefinition.args":          "Program and arguments of exec tasks",
+	"TaskDefinition.with":          "Parameters for custom executors",
+	"TaskDefinition.weight":        "Relative weight of the task in the group progress",
+	"TaskDefinition.exits":         "Exit the program after the task, to continue the workflow on next run",
+	"TaskDefinition.skip":          "Skip the task",
+	"TaskDefinition.skip_cmd":      "Shell command skipping the task when it returns a zero status code",
+	"TaskDefinition.when":          "Expression skipping the task when it is false, like tasks.check.exit_code == 0",
+	"TaskDefinition.depends_on":    "Ids of the tasks of the group that must succeed before this task starts",
+	"TaskDefinition.retries":       "Number of retries when the task fails",
+	"TaskDefinition.retry_delay":   "Delay before the first retry, like \"5s\" or a number of seconds",
+	"TaskDefinition.backoff":       "Factor applied to the retry delay after each retry",
+	"TaskDefinition.timeout":       "Maximum duration of each attempt, like \"30s\" or a number of seconds",
+	"TaskDefinition.allow_failure": "Carry on as if the task succeeded when it fails",
+	"TaskDefinition.env":           "Environment variables of the task and its skip_cmd",
+	"TaskDefinition.inherit_env":   "Environment variables of the program inherited by the task: all, none or a list of names",
+}
+
+// schemaRequired lists the required fields of definitions, by type.
+var schemaRequired = map[string][]string{
+	"Definition":      {"groups"},
+	"GroupDefinition": {"id", "tasks"},
+	"TaskDefinition":  {"id"},
+}
+
+// JSONSchema returns the JSON Schema of workflow definition files, generated
+// from [Definition] and the types it references.
+func JSONSchema() []byte {
+	defs := map[string]any{}
+	result := schemaFor(reflect.TypeFor[Definition](), defs, true)
+	result["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	result["title"] = "Workflow definition"
+	result["$defs"] = defs
+
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(b, '\n')
+}
+
+// schemaFor returns the schema of type t. Structs other than the root are
+// added to defs and referenced.
+func schemaFor(t reflect.Type, defs map[string]any, root bool) map[string]any {
+	if s, ok := reflect.New(t).Interface().(interface{ jsonSchema() map[string]any }); ok {
+		return s.jsonSchema()
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), defs, root)
+
+	case reflect.Struct:
+		if !root {
+			if _, ok := defs[t.Name()]; !ok {
+				defs[t.Name()] = nil // Break recursion
+				defs[t.Name()] = schemaFor(t, defs, true)
+			}
+			return map[string]any{"$ref": "#/$defs/" + t.Name()}
+		}
+
+		properties := map[string]any{}
+		for i, name := range definitionFields(t) {
+			if name == "" {
+				continue
+			}
+			property := schemaFor(t.Field(i).Type, defs, false)
+			if description, ok := schemaDescriptions[t.Name()+"."+name]; ok {
+				property["description"] = description
+			}
+			properties[name] = property
+		}
+		result := map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if required, ok := schemaRequired[t.Name()]; ok {
+			result["required"] = required
+		}
+		return result
+
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), defs, false)}
+
+	case reflect.Map:
+		return map[string]any{"type": "object"}
+
+	case reflect.String:
+		return map[string]any{"type": "string"}
+
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+
+	case reflect.Int:
+		return map[string]any{"type": "integer", "minimum": 0}
+
+	case reflect.Float64:
+		return map[string]any{"type": "number", "minimum": 0}
+	}
+
+	panic("unsupported definition type " + t.String())
+}
+
+func (Duration) jsonSchema() map[string]any {
+	return map[string]any{
+		"oneOf": []any{
+			map[string]any{"type": "string", "pattern": `^(0|([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`},
+			map[string]any{"type": "number", "minimum": 0},
+		},
+	}
+}
+
+func (InheritEnv) jsonSchema() map[string]any {
+	name := map[string]any{"type": "string", "pattern": varNameRegexp.String()}
+	return map[string]any{
+		"oneOf": []any{
+			map[string]any{"enum": []any{"all", "none"}},
+			map[string]any{"type": "array", "items": name},
+		},
+	}
+}
+
+func (VarDefinitions) jsonSchema() map[string]any {
+	return map[string]any{
+		"type":          "object",
+		"propertyNames": map[string]any{"pattern": varNameRegexp.String()},
+		"additionalProperties": map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "string"},
+				schemaFor(reflect.TypeFor[VarDefinition](), nil, true),
+			},
+		},
+	}
+}
@@ -0,0 +1,171 @@
+package workflow
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Test that the embedded schema file is up to date
+func TestSchemaFile(t *testing.T) {
+	if string(JSONSchema()) != Schema {
+		t.Fatal("workflow.schema.json is out of date, run go generate")
+	}
+}
+
+// Test that every field of the definitions is described in the schema
+func TestSchemaDescriptions(t *testing.T) {
+	schema := loadSchema(t)
+	for name, def := range map[string]any{
+		"Definition":      schema,
+		"GroupDefinition": schema["$defs"].(map[string]any)["GroupDefinition"],
+		"TaskDefinition":  schema["$defs"].(map[string]any)["TaskDefinition"],
+	} {
+		for property, s := range def.(map[string]any)["properties"].(map[string]any) {
+			if _, ok := s.(map[string]any)["description"]; !ok {
+				t.Errorf("%s.%s has no description", name, property)
+			}
+		}
+	}
+}
+
+// Test that a document using every property of the schema is accepted by the
+// parser
+func TestSchemaParser(t *testing.T) {
+	schema := loadSchema(t)
+
+	b, err := yaml.Marshal(schemaSample(t, schema, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parseDefinition("sample.yaml", b)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, b)
+	}
+}
+
+// Test that fields used in test definitions are described in the schema
+func TestSchemaTestData(t *testing.T) {
+	schema := loadSchema(t)
+
+	files, err := filepath.Glob("test_data/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseDefinition(file, b); err != nil {
+			// Invalid on purpose
+			continue
+		}
+		root := yaml.Node{}
+		if err := yaml.Unmarshal(b, &root); err != nil {
+			t.Fatal(err)
+		}
+		checkSchemaNode(t, schema, schema, root.Content[0], file)
+	}
+}
+
+func loadSchema(t *testing.T) map[string]any {
+	schema := map[string]any{}
+	err := json.Unmarshal(JSONSchema(), &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// resolveSchema follows s if it is a reference.
+func resolveSchema(root, s map[string]any) map[string]any {
+	ref, ok := s["$ref"].(string)
+	if !ok {
+		return s
+	}
+	name := strings.TrimPrefix(ref, "#/$defs/")
+	return root["$defs"].(map[string]any)[name].(map[string]any)
+}
+
+// schemaSample returns a value valid for schema s, with all properties set.
+func schemaSample(t *testing.T, root, s map[string]any) any {
+	s = resolveSchema(root, s)
+
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		return schemaSample(t, root, oneOf[len(oneOf)-1].(map[string]any))
+	}
+
+	switch s["type"] {
+	case "object":
+		result := map[string]any{}
+		if properties, ok := s["properties"].(map[string]any); ok {
+			for name, property := range properties {
+				result[name] = schemaSample(t, root, property.(map[string]any))
+			}
+		}
+		if additional, ok := s["additionalProperties"].(map[string]any); ok {
+			result["NAME"] = schemaSample(t, root, additional)
+		}
+		return result
+	case "array":
+		return []any{schemaSample(t, root, s["items"].(map[string]any))}
+	case "string":
+		return "x"
+	case "integer", "number":
+		return 1
+	case "boolean":
+		return true
+	}
+
+	t.Fatalf("unexpected schema %v", s)
+	return nil
+}
+
+// checkSchemaNode checks that the keys of mappings in n are properties of
+// schema s.
+func checkSchemaNode(t *testing.T, root, s map[string]any, n *yaml.Node, path string) {
+	s = resolveSchema(root, s)
+
+	// Check the alternative matching the node, if any
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		for _, alternative := range oneOf {
+			alternative := resolveSchema(root, alternative.(map[string]any))
+			if (n.Kind == yaml.MappingNode && alternative["type"] == "object") || (n.Kind == yaml.SequenceNode && alternative["type"] == "array") {
+				checkSchemaNode(t, root, alternative, n, path)
+				return
+			}
+		}
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		properties, ok := s["properties"].(map[string]any)
+		if !ok {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			property, ok := properties[key].(map[string]any)
+			if !ok {
+				t.Errorf("%s.%s is not in the schema", path, key)
+				continue
+			}
+			checkSchemaNode(t, root, property, n.Content[i+1], path+"."+key)
+		}
+	case yaml.SequenceNode:
+		items, ok := s["items"].(map[string]any)
+		if !ok {
+			t.Errorf("%s is not a list in the schema", path)
+			return
+		}
+		for _, item := range n.Content {
+			checkSchemaNode(t, root, items, item, path)
+		}
+	}
+}
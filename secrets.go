@@ -0,0 +1,155 @@
+package workflow
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// A SecretProvider resolves the value of variables declared with
+// `from: name:reference`, where name is the name the provider is registered
+// with using [Workflow.RegisterSecretProvider].
+//
+// Values are resolved when the workflow starts, and again when it is
+// continued, since they are not written to the status file.
+//
+// Two providers are built-in:
+//
+// - `env` returns the value of the environment variable named reference.
+//
+// - `file` returns the content of the file at path reference, relative to
+// the workflow directory, without trailing white spaces.
+type SecretProvider interface {
+	// Resolve returns the value of the secret ref. It must return when ctx is
+	// done, which happens when the workflow is aborted or a timeout expires.
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// SecretProviderFunc is an adapter to use ordinary functions as secret
+// providers, for example to query a vault.
+type SecretProviderFunc func(ctx context.Context, ref string) (string, error)
+
+// Resolve calls f(ctx, ref).
+func (f SecretProviderFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// envProvider resolves secrets from the environment of the program.
+type envProvider struct{}
+
+func (envProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return value, nil
+}
+
+// fileProvider resolves secrets from files, relative to dir.
+type fileProvider struct {
+	dir string
+}
+
+func (p fileProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	if !path.IsAbs(ref) {
+		ref = path.Join(p.dir, ref)
+	}
+	b, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), " \t\r\n"), nil
+}
+
+// RegisterSecretProvider registers provider for variables declared with
+// `from: name:reference`, replacing any provider previously registered with
+// this name, including built-in ones.
+func (w *Workflow) RegisterSecretProvider(name string, provider SecretProvider) {
+	w.Lock()
+	defer w.Unlock()
+	w.providers[name] = provider
+}
+
+// resolve returns the value of a variable declared with `from`.
+func (w *Workflow) resolve(ctx context.Context, from string) (string, error) {
+	name, ref, _ := strings.Cut(from, ":")
+	w.Lock()
+	provider, ok := w.providers[name]
+	w.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", WorkflowErrorUnknownSecret, name)
+	}
+	return provider.Resolve(ctx, ref)
+}
+
+// secretMask replaces the values of secret variables outside of task
+// environments.
+const secretMask = "********"
+
+// setVar sets variable name to value. The value of secret variables, and of
+// variables containing a secret, is kept out of the status and replaced by
+// the mask. It returns the value to publish. Caller must hold the lock.
+func (w *Workflow) setVar(name, value string) string {
+	if w.Status.Vars == nil {
+		w.Status.Vars = map[string]string{}
+	}
+
+	secret := w.Status.Definition != nil && w.Status.Definition.Vars.secret(name)
+	if !secret && w.redact(value) == value {
+		delete(w.secrets, name)
+		w.Status.Vars[name] = value
+		return value
+	}
+
+	if w.secrets == nil {
+		w.secrets = map[string]string{}
+	}
+	w.secrets[name] = value
+	w.Status.Vars[name] = secretMask
+	return secretMask
+}
+
+// value returns the value of variable name, including secrets. Caller must
+// hold the lock.
+func (w *Workflow) value(name string) string {
+	if v, ok := w.secrets[name]; ok {
+		return v
+	}
+	return w.Status.Vars[name]
+}
+
+// redact returns s with the values of secrets replaced by the mask. Caller
+// must hold the lock.
+func (w *Workflow) redact(s string) string {
+	// Longest values first, in case a secret contains another one
+	values := slices.SortedFunc(maps.Values(w.secrets), func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+	for _, v := range values {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, secretMask)
+		}
+	}
+	return s
+}
+
+// redactMessage returns the message line sent by a task with secrets masked,
+// including the value of secret variables it publishes, for the standard
+// output of the program.
+func (w *Workflow) redactMessage(line string) string {
+	w.Lock()
+	defer w.Unlock()
+
+	if s, ok := strings.CutPrefix(line, "set:: "); ok {
+		name, _, _ := strings.Cut(s, " ")
+		if w.Status.Definition != nil && w.Status.Definition.Vars.secret(name) {
+			return "set:: " + name + " " + secretMask + "\n"
+		}
+	}
+	return w.redact(line)
+}
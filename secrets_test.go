@@ -0,0 +1,275 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Test that secrets are exported to tasks, and masked everywhere else
+func TestSecrets(t *testing.T) {
+	dir := t.TempDir()
+	wf, _, err := New("test_data/test-secrets.yaml", path.Join(dir, "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep a copy of the status file written during the run
+	wf.RegisterExecutor("check", ExecutorFunc(func(ctx context.Context, e *Execution) error {
+		b, err := os.ReadFile(path.Join(dir, "status.json"))
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path.Join(dir, "copy.json"), b, 0644)
+	}))
+
+	s := httptest.NewServer(wf.EventsHandler())
+	defer s.Close()
+	r, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := read_sse(t, r.Body)
+
+	if task := wf.Status.Groups[0].Tasks[2]; task.Skip || task.Error != "" {
+		t.Fatalf("secrets not available to task2: %+v", task)
+	}
+	if got := wf.Status.Groups[0].Tasks[0].LastMessage; got != "token is "+secretMask {
+		t.Fatalf("unexpected message %q", got)
+	}
+	if wf.Status.Vars["TOKEN"] != secretMask || wf.Status.Vars["URL"] != secretMask || wf.Status.Vars["USER_NAME"] != "admin" {
+		t.Fatalf("unexpected vars %v", wf.Status.Vars)
+	}
+
+	status, err := json.Marshal(&wf.Status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	persisted, err := os.ReadFile(path.Join(dir, "copy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads := map[string]string{"status": string(status), "status file": string(persisted)}
+	for _, e := range events {
+		b, _ := json.Marshal(e)
+		payloads[string(e.Type)] += string(b)
+	}
+	for name, payload := range payloads {
+		if strings.Contains(payload, "hunter2") {
+			t.Errorf("secret leaked in %s: %s", name, payload)
+		}
+	}
+
+	// Continuing from the status file initializes secrets again
+	wf, _, err = New("test_data/test-secrets.yaml", path.Join(dir, "copy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wf.loadVars(context.Background(), wf.Status.Definition.Vars, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars := wf.vars(); vars["TOKEN"] != "hunter2" || vars["USER_NAME"] != "admin" {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+}
+
+// Test masking messages written to the standard output
+func TestRedactMessage(t *testing.T) {
+	wf, _, err := New("test_data/test-secrets.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.setVar("TOKEN", "hunter2")
+
+	tests := map[string]string{
+		"output:: token hunter2\n": "output:: token ********\n",
+		"set:: TOKEN other\n":      "set:: TOKEN ********\n",
+		"set:: URL x:hunter2\n":    "set:: URL x:********\n",
+		"progress:: 0.5\n":         "progress:: 0.5\n",
+	}
+	for line, want := range tests {
+		if got := wf.redactMessage(line); got != want {
+			t.Errorf("%q: want %q, got %q", line, want, got)
+		}
+	}
+}
+
+// Test resolving variables with secret providers, again when continuing
+func TestSecretProviders(t *testing.T) {
+	t.Setenv("WORKFLOW_TEST_TOKEN", "env-token")
+	dir := t.TempDir()
+
+	resolved := 0
+	vault := SecretProviderFunc(func(ctx context.Context, ref string) (string, error) {
+		if ref != "db/password" {
+			return "", errors.New("not found")
+		}
+		resolved++
+		return "vault-token", nil
+	})
+	// Keep a copy of the status file written during the run
+	statusPath := path.Join(dir, "status.json")
+	check := ExecutorFunc(func(ctx context.Context, e *Execution) error {
+		b, err := os.ReadFile(statusPath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path.Join(dir, "copy.json"), b, 0644)
+	})
+
+	wf, _, err := New("test_data/test-providers.yaml", statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wf.RegisterExecutor("check", check)
+
+	// Providers must be registered
+	err = wf.Start()
+	if !errors.Is(err, WorkflowErrorUnknownSecret) {
+		t.Fatalf("want %v, got %v", WorkflowErrorUnknownSecret, err)
+	}
+
+	wf.RegisterSecretProvider("vault", vault)
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"FROM_ENV", "FROM_FILE", "FROM_VAULT"} {
+		if wf.Status.Vars[name] != secretMask {
+			t.Fatalf("unexpected vars %v", wf.Status.Vars)
+		}
+	}
+
+	b, err := os.ReadFile(path.Join(dir, "copy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	persisted := Status{}
+	err = json.Unmarshal(b, &persisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range persisted.Vars {
+		if value != secretMask {
+			t.Fatalf("secret %s persisted as %q", name, value)
+		}
+	}
+
+	// Continuing after the program exited resolves secrets again
+	statusPath = path.Join(dir, "copy.json")
+	wf, _, err = New("test_data/test-providers.yaml", statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.RegisterSecretProvider("vault", vault)
+	wf.RegisterExecutor("check", check)
+	err = wf.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != 2 || wf.Status.Groups[0].Tasks[1].Attempt != 1 {
+		t.Fatalf("unexpected continued run, resolved %d times, status %+v", resolved, wf.Status.Groups[0].Tasks[1])
+	}
+}
+
+// Test that variables containing a secret are initialized again when
+// continuing
+func TestSecretsContinue(t *testing.T) {
+	t.Setenv("WORKFLOW_TEST_TOKEN", "env-token")
+	dir := t.TempDir()
+
+	// Keep a copy of the status file written during the run
+	statusPath := path.Join(dir, "status.json")
+	check := ExecutorFunc(func(ctx context.Context, e *Execution) error {
+		b, err := os.ReadFile(statusPath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path.Join(dir, "copy.json"), b, 0644)
+	})
+
+	wf, _, err := New("test_data/test-secrets-continue.yaml", statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.RegisterExecutor("check", check)
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wf.Status.Vars["URL"] != secretMask {
+		t.Fatalf("unexpected vars %v", wf.Status.Vars)
+	}
+
+	statusPath = path.Join(dir, "copy.json")
+	wf, _, err = New("test_data/test-secrets-continue.yaml", statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.RegisterExecutor("check", check)
+	err = wf.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task := wf.Status.Groups[0].Tasks[1]; task.Attempt != 1 || task.Error != "" {
+		t.Fatalf("unexpected continued run %+v", task)
+	}
+}
+
+// Test that required secret inputs must be given again when continuing
+func TestSecretInputsContinue(t *testing.T) {
+	dir := t.TempDir()
+
+	// Keep a copy of the status file written during the run
+	statusPath := path.Join(dir, "status.json")
+	check := ExecutorFunc(func(ctx context.Context, e *Execution) error {
+		b, err := os.ReadFile(statusPath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path.Join(dir, "copy.json"), b, 0644)
+	})
+
+	wf, _, err := New("test_data/test-secret-inputs.yaml", statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.RegisterExecutor("check", check)
+	err = wf.StartWithInputs(map[string]string{"PASSWORD": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statusPath = path.Join(dir, "copy.json")
+	wf, _, err = New("test_data/test-secret-inputs.yaml", statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.RegisterExecutor("check", check)
+	err = wf.Continue()
+	if !errors.Is(err, WorkflowErrorInvalidInput) || !strings.Contains(err.Error(), "missing required PASSWORD") {
+		t.Fatalf("want %v, got %v", WorkflowErrorInvalidInput, err)
+	}
+
+	err = wf.ContinueWithInputs(map[string]string{"PASSWORD": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task := wf.Status.Groups[0].Tasks[1]; task.Attempt != 1 || task.Error != "" {
+		t.Fatalf("unexpected continued run %+v", task)
+	}
+}
@@ -0,0 +1,87 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// EventsHandler returns an http.Handler streaming the workflow [Event]s as
+// server-sent events, for clients that can't use the websocket handler
+// returned by [New], like browsers behind proxies breaking websocket upgrades,
+// or scripts:
+//
+//	curl -N http://localhost:8080/events
+//
+// Each event is sent with its sequence number as id and the same JSON payload
+// as on websockets as data. Clients reconnecting with the Last-Event-ID
+// header, which browsers set automatically, or the since query parameter,
+// receive the events they missed, or a new snapshot. The stream ends once the
+// run is finished.
+func (w *Workflow) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if w.authorize(r, PermissionRead) == PermissionNone {
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		since, err := parseSince(r)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rc := http.NewResponseController(rw)
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+		rw.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			slog.Error("unable to stream events", "error", err)
+			return
+		}
+
+		// Register the client under the lock, so that it doesn't miss any
+		// event, nor gets one twice
+		w.Lock()
+		messages, err := w.replay(since)
+		if err != nil {
+			w.Unlock()
+			slog.Error("unable to write initial status to event stream", "error", err)
+			return
+		}
+		c := w.clients.add(&sseSink{rw: rw, rc: rc}, messages...)
+		w.Unlock()
+
+		select {
+		case <-r.Context().Done():
+			w.clients.remove(c, websocket.StatusNormalClosure, "")
+		case <-c.done:
+		}
+		<-c.done
+	})
+}
+
+// sseSink is a server-sent events hub client.
+type sseSink struct {
+	rw http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s *sseSink) write(ctx context.Context, m message) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		// Not all response writers support deadlines
+		_ = s.rc.SetWriteDeadline(deadline)
+	}
+	_, err := fmt.Fprintf(s.rw, "id: %d\ndata: %s\n\n", m.seq, m.data)
+	if err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// close does nothing, the response ends when the handler returns.
+func (s *sseSink) close(websocket.StatusCode, string) {}
@@ -0,0 +1,127 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// read_sse reads server-sent events from r until it is closed, and returns
+// them. It fails if the id of an event is not its sequence number.
+func read_sse(t *testing.T, r io.Reader) []Event {
+	t.Helper()
+	result := []Event{}
+	id := ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			e := Event{}
+			err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != strconv.FormatUint(e.Seq, 10) {
+				t.Fatalf("want id %d, got %q", e.Seq, id)
+			}
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Test following a run with server-sent events
+func TestEventsHandler(t *testing.T) {
+	wf, _, err := New("test_data/test-events.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(wf.EventsHandler())
+	defer s.Close()
+
+	r, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", r.Header.Get("Content-Type"))
+	}
+
+	go func() {
+		if err := wf.Start(); err != nil {
+			slog.Error("workflow failed", "error", err)
+		}
+	}()
+
+	// The stream ends with the run
+	events := read_sse(t, r.Body)
+	if len(events) != int(wf.Status.Seq)+1 {
+		t.Fatalf("want %d events, got %d", wf.Status.Seq+1, len(events))
+	}
+	if events[0].Type != EventSnapshot || events[len(events)-1].Type != EventWorkflowFinished {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if got := messages(events); got != "hello\n" {
+		t.Fatalf("want %q, got %q", "hello\n", got)
+	}
+}
+
+// Test resuming server-sent events with the Last-Event-ID header
+func TestEventsHandlerResume(t *testing.T) {
+	wf, _, err := New("test_data/test-events.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptest.NewServer(wf.EventsHandler())
+	defer s.Close()
+
+	// get returns the events received within a moment with Last-Event-ID id
+	get := func(id string) (int, []Event) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+		req.Header.Set("Last-Event-ID", id)
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		return r.StatusCode, read_sse(t, r.Body)
+	}
+
+	code, events := get("5")
+	if code != http.StatusOK || len(events) != int(wf.Status.Seq)-5 || events[0].Seq != 6 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	code, events = get(strconv.FormatUint(wf.Status.Seq+1, 10))
+	if code != http.StatusOK || len(events) != 1 || events[0].Type != EventSnapshot {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	code, _ = get("x")
+	if code != http.StatusBadRequest {
+		t.Fatalf("want status %d, got %d", http.StatusBadRequest, code)
+	}
+}
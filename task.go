@@ -1,25 +1,60 @@
 package workflow
 
 import (
-	"bufio"
 	"context"
 	"errors"
-	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
-	"path"
-	"syscall"
 )
 
 // A Task represents a command to be run in the workflow.
 //
+// The `type` of a task selects the [Executor] running it, `shell` by default,
+// which runs `cmd` with bash. `exec` runs the program and arguments in `args`
+// without a shell, and custom types can be registered with
+// [Workflow.RegisterExecutor], receiving parameters from `with`.
+//
 // You can specify a weight for all tasks to have a meaningful progress
 // percentage at the group and at the workflow level. For example, a task that
 // is known to execute very quickly can be given a weight of 5, while a task
 // that is known to execute for a long time can be given a weight of 100.
 //
+// A task can declare the ids of tasks of the same group it depends on with
+// `depends_on`. It will only start once all of them succeeded, and will be
+// marked as `Blocked` if one of them fails. Tasks without `depends_on` depend
+// on the previous task of the group, unless the group is parallel.
+//
+// A task running longer than `timeout` is killed and fails with
+// [WorkflowErrorTimeout]. The timeout applies to each attempt.
+//
+// Like groups, tasks can be skipped by setting `skip` to true, or with a
+// `skip_cmd` returning a zero status code. skip_cmd is run with the
+// environment of the task in the workflow directory, right before the task
+// would start.
+// Tasks can also declare a `when` expression, evaluated right before the task
+// would start, and are skipped if it is false. See [Group] for the expression
+// syntax.
+//
+// Skipped tasks don't count in the group progress, and tasks depending on them
+// run as if they succeeded.
+//
+// A failing task can be retried up to `retries` times, waiting `retry_delay`
+// before the first retry. The delay is multiplied by `backoff` after each
+// retry. Every attempt is recorded in `Attempts`.
+//
+// When `allow_failure` is set to true, a failure of the task is recorded in
+// `Error` and flagged as a `Warning`, but the workflow carries on as if the
+// task succeeded.
+//
+// Tasks run with the environment variables of the program selected by
+// `inherit_env`, either `all`, `none` or a list of names, then the workflow
+// variables, then the variables of `env`. Both can be set on the workflow,
+// the group and the task: the most specific inherit_env applies, and env
+// mappings are merged, the task overriding the group and the group the
+// workflow. Commands of variables run with the environment of the workflow.
+//
 // If `exits` is set to true, the running program will exit after the task,
 // and next time the workflow is run with [Continue] it will pick up right
 // after this task, marking it as finished. The is useful for workflows that
@@ -36,16 +71,61 @@ import (
 //
 // - `error`: will send an error description if something unexpected happens,
 // and will be available in `Error` field of task, group and workflow.
+//
+// - `set NAME value`: will publish a variable, added to the workflow `Vars`
+// and exported to the environment of every subsequent task and skip_cmd.
+// Calls that don't start with a variable name, like `set -e`, still invoke the
+// shell builtin.
+//
+// - `prompt NAME question`: will ask a question to the users of the workflow,
+// available in `Prompt` for the task, and wait for the answer given with
+// [Workflow.Answer]. The answer is stored in NAME and published like with
+// `set`.
 type Task struct {
 	Id     string `json:"id"`
+	Type   string `json:"type,omitempty"`
 	Cmd    string `json:"cmd"`
 	Weight int    `json:"weight"`
 	Exits  bool   `json:"exits"`
 
-	Started  bool    `json:"started"`
-	Finished bool    `json:"finished"`
-	Percent  float64 `json:"percent"`
-	Error    string  `json:"error"`
+	Args []string       `json:"args,omitempty"` // Program and arguments for the exec executor
+	With map[string]any `json:"with,omitempty"` // Parameters for custom executors
+
+	SkipCmd string `json:"skipCmd,omitempty"`
+	When    string `json:"when,omitempty"`
+	Skip    bool   `json:"skip"`
+
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	Retries    int      `json:"retries,omitempty"`
+	RetryDelay Duration `json:"retryDelay,omitempty"`
+	Backoff    float64  `json:"backoff,omitempty"`
+
+	Timeout Duration `json:"timeout,omitempty"`
+
+	AllowFailure bool `json:"allowFailure,omitempty"`
+
+	Env        map[string]string `json:"env,omitempty"`
+	InheritEnv *InheritEnv       `json:"inheritEnv,omitempty"`
+
+	Started     bool    `json:"started"`
+	Finished    bool    `json:"finished"`
+	Percent     float64 `json:"percent"`
+	LastMessage string  `json:"lastMessage"`
+	Error       string  `json:"error"`
+	Blocked     bool    `json:"blocked"` // A dependency failed
+	ExitCode    int     `json:"exitCode"`
+	TimedOut    bool    `json:"timedOut"`
+	Warning     bool    `json:"warning"` // Task failed but was allowed to
+
+	Attempt  int       `json:"attempt"`            // Current attempt, starting at 1
+	Attempts []Attempt `json:"attempts,omitempty"` // Finished attempts
+
+	Prompt *Prompt `json:"prompt,omitempty"` // Question waiting for an answer
+
+	answers chan string              // Answers to prompts of the running attempt
+	redact  func(line string) string // Masks secrets in messages written to the standard output
+	output  io.Writer                // Standard output, os.Stdout if nil
 
 	cmd        *exec.Cmd
 	cmd_Stdout io.WriteCloser
@@ -57,185 +137,145 @@ type Task struct {
 	wfout  io.ReadCloser `json:"-"`
 }
 
-func newTask(y map[string]any) (*Task, error) {
-	id, ok := y["id"].(string)
-	if !ok {
-		return nil, WorkflowErrorTaskMissingId
-	}
+// Prompt is a question asked by a task with the `prompt` shell function.
+type Prompt struct {
+	Name     string `json:"name"`     // Variable receiving the answer
+	Question string `json:"question"` // Question asked
+}
 
-	cmd, ok := y["cmd"].(string)
-	if !ok || cmd == "" {
-		return nil, WorkflowErrorTaskMissingCommand
-	}
+// Attempt is the outcome of a single run of a task.
+type Attempt struct {
+	Number   int    `json:"number"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
 
-	var weight int
-	weight, _ = y["weight"].(int)
+func newTask(def *TaskDefinition) (*Task, error) {
+	if errs := def.check(); len(errs) > 0 {
+		return nil, errs[0]
+	}
 
+	weight := def.Weight
 	if weight == 0 {
 		weight = 1
 	}
 
-	var exits bool
-	exits, _ = y["exits"].(bool)
-
 	return &Task{
-		Id:     id,
-		Cmd:    cmd,
-		Weight: weight,
-		Exits:  exits,
+		Id:         def.Id,
+		Type:       def.Type,
+		Cmd:        def.Cmd,
+		Args:       def.Args,
+		With:       def.With,
+		Weight:     weight,
+		Exits:      def.Exits,
+		SkipCmd:    def.SkipCmd,
+		When:       def.When,
+		Skip:       def.Skip,
+		DependsOn:  def.DependsOn,
+		Retries:    def.Retries,
+		RetryDelay: def.RetryDelay,
+		Backoff:    def.Backoff,
+		Timeout:    def.Timeout,
+
+		AllowFailure: def.AllowFailure,
+		Env:          def.Env,
+		InheritEnv:   def.InheritEnv,
 	}, nil
 }
 
-func (t *Task) run(ctx context.Context, cwd string) error {
+// backoff returns the factor applied to the retry delay after each retry.
+func (t *Task) backoff() float64 {
+	if t.Backoff == 0 {
+		return 1
+	}
+	return t.Backoff
+}
 
-	cmd := exec.Command("/bin/bash", "-c", `
-	function output() {
-		[ -p "$WFOUT" ] && echo "output:: $*" > "$WFOUT"
+// exitCode returns the exit code of a command that returned err.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
 	}
-	function progress() {
-		[ -p "$WFOUT" ] && echo "progress:: $*" > "$WFOUT"
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
 	}
-	function error() {
-		[ -p "$WFOUT" ] && echo "error:: $*" > "$WFOUT"
+	return -1
+}
+
+// run runs the task with executor in directory cwd.
+func (t *Task) run(ctx context.Context, cwd string, executor Executor) error {
+	// Whatever happens, readers of wfout must be released when run returns
+	defer t.closeWFout()
+
+	output := t.output
+	if output == nil {
+		output = os.Stdout
 	}
-	`+t.Cmd)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	cmd.Dir = cwd
-	t.cmd = cmd
-
-	// Add variables to the environment
-	vars, ok := ctx.Value(contextKeyVars).(map[string]string)
-	if ok {
-		for k, v := range vars {
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
-		}
+
+	e := &Execution{
+		Task:     t,
+		Dir:      cwd,
+		messages: t.cmd_WFout,
+		answers:  t.answers,
+		redact:   t.redact,
+		output:   output,
 	}
 
+	// Environment and variables, see Workflow.environ
+	env, _ := ctx.Value(contextKeyEnv).([]string)
+	e.Env = append([]string{}, env...)
+
 	// Connect Stdout & Stderr
 	if t.stdout == nil {
-		slog.Debug("setting Stdout to os.Stdout")
-		cmd.Stdout = os.Stdout
+		slog.Debug("setting Stdout to the workflow output")
+		e.Stdout = e.output
 	} else {
 		slog.Debug("setting Stdout to cmd_Stdout")
-		cmd.Stdout = t.cmd_Stdout
+		e.Stdout = t.cmd_Stdout
 	}
 
 	if t.stderr == nil {
 		slog.Debug("setting Stderr to os.Stderr")
-		cmd.Stderr = os.Stderr
+		e.Stderr = os.Stderr
 	} else {
 		slog.Debug("setting Stderr to cmd_Stderr")
-		cmd.Stderr = t.cmd_Stderr
+		e.Stderr = t.cmd_Stderr
 	}
 
-	// Create local fifo for messages
-
-	wfout_dir_path, err := os.MkdirTemp("", "workflow.*")
-	if err != nil {
-		return nil
-	}
-	wfout_path := path.Join(wfout_dir_path, ".task")
-	defer os.RemoveAll(wfout_dir_path)
-	err = syscall.Mknod(wfout_path, syscall.S_IFIFO|0666, 0)
-	if err != nil {
-		return err
-	}
+	err := executor.Execute(ctx, e)
 
-	cmd.Env = append(cmd.Env, fmt.Sprintf("WFOUT=%s", wfout_path))
-
-	block_output := make(chan struct{})
-	block_start := make(chan struct{})
-
-	var outputf *os.File
-
-	go func() {
-		outputf, err = os.OpenFile(wfout_path, os.O_RDWR, 0)
-		if err != nil {
-			slog.Error("unable to open fifo", "error", err)
-			close(block_start)
-			return
-		}
-		close(block_start)
-		defer func() {
-			err := outputf.Close()
-			if err != nil {
-				slog.Error("error while closing fifo", "error", err)
-			}
-		}()
-
-		rd := bufio.NewReader(outputf)
-		for {
-			s, err := rd.ReadString('\n')
-			if err != nil {
-				if err.Error() == "EOF" {
-					break
-				}
-				slog.Error("error while reading fifo", "error", err)
-				break
-			}
-
-			if s == "end::\n" {
-				close(block_output)
-				break
-			}
-
-			_, err = os.Stdout.WriteString(s)
-			if err != nil {
-				slog.Error("error while writing to Stdout", "error", err)
-				break
-			}
-
-			if t.cmd_WFout != nil {
-				_, err = t.cmd_WFout.Write([]byte(s))
-				if err != nil {
-					slog.Error("error while writing to WFout", "error", err)
-					break
-				}
-			}
-		}
-	}()
-
-	<-block_start
-	err = cmd.Start()
-	if err != nil {
-		slog.Error("error while starting command", "error", err)
-		if t.Error == "" {
-			t.Error = err.Error()
-		}
+	// Executors may not honor ctx as fast as they should, report the reason
+	// it is done anyway
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
 	}
-	cmdErr := cmd.Wait()
 
-	_, err = outputf.WriteString("end::\n")
-	if err != nil {
-		return err
+	if closeErr := t.closeWFout(); err == nil {
+		err = closeErr
 	}
 
-	<-block_output
+	return err
+}
 
-	if t.cmd_WFout != nil {
-		err := t.cmd_WFout.Close()
-		if err != nil {
-			return err
-		}
+// closeWFout closes the writing end of the wfout pipe, if any, so readers
+// get an EOF.
+func (t *Task) closeWFout() error {
+	if t.cmd_WFout == nil {
+		return nil
 	}
-
-	return cmdErr
+	err := t.cmd_WFout.Close()
+	t.cmd_WFout = nil
+	t.wfout = nil
+	return err
 }
 
 func (t *Task) abort() error {
 	slog.Warn("aborting task", "task", t.Id)
-	pgid, err := syscall.Getpgid(t.cmd.Process.Pid)
-	if err == nil {
-		_ = syscall.Kill(-pgid, 15) // note the minus sign
-	}
-	//err := t.cmd.Process.Signal(syscall.SIGINT)
-	//pid := t.cmd.Process.Pid
-	//err := t.cmd.Process.Kill()
-	if err != nil {
-		return err
+	if t.cmd == nil {
+		return nil
 	}
-	return nil
+	return killGroup(t.cmd)
 }
 
 func (t *Task) stdoutPipe() (io.ReadCloser, error) {
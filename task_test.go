@@ -8,9 +8,9 @@ import (
 )
 
 func TestTaskNoOutput(t *testing.T) {
-	task, err := newTask(map[string]any{
-		"id": "tast-task",
-		"cmd": `echo "sample text"
+	task, err := newTask(&TaskDefinition{
+		Id: "tast-task",
+		Cmd: `echo "sample text"
 		output sample output`,
 	})
 
@@ -18,16 +18,16 @@ func TestTaskNoOutput(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = task.run(context.Background(), "")
+	err = task.run(context.Background(), "", shellExecutor{})
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
 func TestTaskOutput(t *testing.T) {
-	task, err := newTask(map[string]any{
-		"id": "tast-task",
-		"cmd": `echo "sample text"
+	task, err := newTask(&TaskDefinition{
+		Id: "tast-task",
+		Cmd: `echo "sample text"
 		output sample output`,
 	})
 
@@ -41,7 +41,9 @@ func TestTaskOutput(t *testing.T) {
 	}
 
 	output := ""
+	outputDone := make(chan struct{})
 	go func() {
+		defer close(outputDone)
 		rd := bufio.NewReader(wfout)
 		for {
 			s, err := rd.ReadString('\n')
@@ -62,7 +64,9 @@ func TestTaskOutput(t *testing.T) {
 	}
 
 	stdoutstr := ""
+	stdoutDone := make(chan struct{})
 	go func() {
+		defer close(stdoutDone)
 		rd := bufio.NewReader(stdout)
 		for {
 			s, err := rd.ReadString('\n')
@@ -77,11 +81,16 @@ func TestTaskOutput(t *testing.T) {
 		}
 	}()
 
-	err = task.run(context.Background(), ".")
+	err = task.run(context.Background(), ".", shellExecutor{})
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	// Wait for the readers to be done
+	_ = task.cmd_Stdout.Close()
+	<-outputDone
+	<-stdoutDone
+
 	want := "output:: sample output\n"
 	if output != want {
 		t.Fatalf("want %q, got %q", want, output)
@@ -0,0 +1,316 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Validate checks the workflow definition file at path, without reading or
+// writing any status file. It returns nil if the definition is valid, or all
+// the problems found joined, each as a [DefinitionError] carrying its
+// position in the file.
+func Validate(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	definition, errs := decodeDefinition(path, b)
+	if definition != nil {
+		errs = append(errs, definition.check()...)
+	}
+	return errors.Join(errs...)
+}
+
+// check returns all the problems of the definition.
+func (def *Definition) check() []error {
+	errs := []error{}
+
+	for _, v := range def.Vars {
+		errs = append(errs, v.check()...)
+	}
+	ids, dependsOn := def.Vars.dependencies()
+	positions := []Position{}
+	for _, v := range def.Vars {
+		positions = append(positions, v.pos)
+	}
+	errs = append(errs, checkGraph(ids, dependsOn, positions, false)...)
+	errs = append(errs, checkEnv(def.pos, def.Env, def.InheritEnv)...)
+
+	if def.Groups == nil {
+		errs = append(errs, def.pos.wrap(WorkflowErrorNoGroups))
+	}
+	errs = append(errs, checkGroups(def.Groups)...)
+
+	for _, hooks := range [][]*GroupDefinition{def.OnSuccess, def.OnFailure, def.Finally} {
+		errs = append(errs, checkGroups(hooks)...)
+
+		// A hook can't exit the program, it would never be run again
+		for _, group := range hooks {
+			for _, task := range group.Tasks {
+				if task.Exits {
+					errs = append(errs, task.pos.wrap(WorkflowErrorHookExits))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkEnv returns the invalid names of env and inherit, the environment of
+// the definition at pos.
+func checkEnv(pos Position, env map[string]string, inherit *InheritEnv) []error {
+	names := slices.Sorted(maps.Keys(env))
+	if inherit != nil {
+		names = append(names, inherit.Names...)
+	}
+
+	errs := []error{}
+	for _, name := range names {
+		if !varNameRegexp.MatchString(name) {
+			errs = append(errs, pos.wrap(fmt.Errorf("%w: invalid name %q", WorkflowErrorInvalidEnv, name)))
+		}
+	}
+	return errs
+}
+
+// check returns all the problems of the variable definition.
+func (def *VarDefinition) check() []error {
+	errorf := func(format string, args ...any) []error {
+		return []error{def.pos.wrap(fmt.Errorf("%w: %s", WorkflowErrorInvalidVars, fmt.Sprintf(format, args...)))}
+	}
+
+	if !varNameRegexp.MatchString(def.Name) {
+		return errorf("invalid name %q", def.Name)
+	}
+
+	sources := 0
+	for _, source := range []string{def.Value, def.Cmd, def.From} {
+		if source != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		return errorf("only one of value, cmd and from can be set for %s", def.Name)
+	case sources == 1 && (def.Default != "" || def.Required):
+		return errorf("default and required are only allowed for inputs, %s has a value", def.Name)
+	case def.Required && def.Default != "":
+		return errorf("required input %s can't have a default", def.Name)
+	case def.Secret && (def.Value != "" || def.Default != ""):
+		return errorf("secret %s can't have a literal value", def.Name)
+	case def.From != "":
+		if scheme, ref, ok := strings.Cut(def.From, ":"); !ok || scheme == "" || ref == "" {
+			return errorf("invalid from %q for %s, expected provider:reference", def.From, def.Name)
+		}
+	}
+
+	switch def.Type {
+	case "", "string", "int", "bool":
+		if len(def.Values) > 0 {
+			return errorf("values are only allowed for enum variables, %s is %q", def.Name, def.Type)
+		}
+	case "enum":
+		if len(def.Values) == 0 {
+			return errorf("missing values for enum %s", def.Name)
+		}
+	default:
+		return errorf("invalid type %q for %s, expected string, int, bool or enum", def.Type, def.Name)
+	}
+
+	for _, v := range []string{def.Value, def.Default} {
+		if v == "" {
+			continue
+		}
+		if err := def.validate(v); err != nil {
+			return errorf("%s", err)
+		}
+	}
+	return nil
+}
+
+// validate returns an error if value is not valid for the type of the
+// variable.
+func (def *VarDefinition) validate(value string) error {
+	// Secrets must not show up in errors
+	shown := strconv.Quote(value)
+	if def.secret() {
+		shown = secretMask
+	}
+
+	switch def.Type {
+	case "int":
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s must be an integer, got %s", def.Name, shown)
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be a boolean, got %s", def.Name, shown)
+		}
+	case "enum":
+		if !slices.Contains(def.Values, value) {
+			return fmt.Errorf("%s must be one of %s, got %s", def.Name, strings.Join(def.Values, ", "), shown)
+		}
+	}
+	return nil
+}
+
+// checkGroups returns all the problems of a list of groups, including the
+// dependencies between them.
+func checkGroups(defs []*GroupDefinition) []error {
+	errs := []error{}
+	ids := []string{}
+	dependsOn := [][]string{}
+	positions := []Position{}
+	for _, group := range defs {
+		errs = append(errs, group.check()...)
+		ids = append(ids, group.Id)
+		dependsOn = append(dependsOn, group.DependsOn)
+		positions = append(positions, group.pos)
+	}
+	graphErrs := checkGraph(ids, dependsOn, positions, true)
+	if len(graphErrs) > 0 {
+		return append(errs, graphErrs...)
+	}
+
+	// Exiting the program would kill the other running groups
+	g, err := newGraph(ids, dependsOn, true)
+	if err != nil {
+		return append(errs, err)
+	}
+	for i, group := range defs {
+		if group.Parallel || !g.concurrent(i) {
+			continue
+		}
+		for _, task := range group.Tasks {
+			if task.Exits {
+				errs = append(errs, task.pos.wrap(WorkflowErrorConcurrentExits))
+			}
+		}
+	}
+	return errs
+}
+
+// check returns all the problems of the group definition, including the ones
+// of its tasks.
+func (def *GroupDefinition) check() []error {
+	errs := []error{}
+
+	if def.Id == "" {
+		errs = append(errs, def.pos.wrap(WorkflowErrorGroupMissingId))
+	}
+
+	if def.Tasks == nil {
+		errs = append(errs, def.pos.wrap(WorkflowErrorGroupMissingTasks))
+	}
+
+	errs = append(errs, checkEnv(def.pos, def.Env, def.InheritEnv)...)
+
+	if def.Timeout < 0 {
+		errs = append(errs, def.pos.wrap(WorkflowErrorInvalidTimeout))
+	}
+
+	if def.When != "" {
+		if _, err := compileExpr(def.When); err != nil {
+			errs = append(errs, def.pos.wrap(err))
+		}
+	}
+
+	ids := []string{}
+	dependsOn := [][]string{}
+	positions := []Position{}
+	for _, task := range def.Tasks {
+		errs = append(errs, task.check()...)
+
+		if task.Exits {
+			if def.Parallel {
+				errs = append(errs, task.pos.wrap(WorkflowErrorParallelExits))
+			}
+			if def.Skip {
+				errs = append(errs, task.pos.wrap(WorkflowErrorUnreachableExits))
+			}
+		}
+
+		ids = append(ids, task.Id)
+		dependsOn = append(dependsOn, task.DependsOn)
+		positions = append(positions, task.pos)
+	}
+
+	return append(errs, checkGraph(ids, dependsOn, positions, !def.Parallel)...)
+}
+
+// check returns all the problems of the task definition.
+func (def *TaskDefinition) check() []error {
+	errs := []error{}
+
+	if def.Id == "" {
+		errs = append(errs, def.pos.wrap(WorkflowErrorTaskMissingId))
+	}
+
+	// Built-in executors need a command
+	if def.Cmd == "" && (def.Type == "" || def.Type == "shell" || (def.Type == "exec" && len(def.Args) == 0)) {
+		errs = append(errs, def.pos.wrap(WorkflowErrorTaskMissingCommand))
+	}
+
+	if def.Weight < 0 {
+		errs = append(errs, def.pos.wrap(WorkflowErrorInvalidWeight))
+	}
+
+	if def.Retries < 0 || def.RetryDelay < 0 || def.Backoff < 0 {
+		errs = append(errs, def.pos.wrap(WorkflowErrorInvalidRetries))
+	}
+
+	errs = append(errs, checkEnv(def.pos, def.Env, def.InheritEnv)...)
+
+	if def.Timeout < 0 {
+		errs = append(errs, def.pos.wrap(WorkflowErrorInvalidTimeout))
+	}
+
+	if def.When != "" {
+		if _, err := compileExpr(def.When); err != nil {
+			errs = append(errs, def.pos.wrap(err))
+		}
+	}
+
+	// The workflow would never exit to be continued
+	if def.Exits && def.Skip {
+		errs = append(errs, def.pos.wrap(WorkflowErrorUnreachableExits))
+	}
+
+	return errs
+}
+
+// checkGraph returns the problems of the dependencies between sibling nodes,
+// reporting every duplicate id, and the first dependency error otherwise.
+func checkGraph(ids []string, dependsOn [][]string, positions []Position, sequential bool) []error {
+	// Missing ids are reported with their node
+	if slices.Contains(ids, "") {
+		return nil
+	}
+
+	errs := []error{}
+	for i, id := range ids {
+		if slices.Index(ids, id) < i {
+			errs = append(errs, positions[i].wrap(&DependencyError{Id: id, Err: WorkflowErrorDuplicateId}))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	_, err := newGraph(ids, dependsOn, sequential)
+	if err != nil {
+		var dependencyErr *DependencyError
+		if errors.As(err, &dependencyErr) {
+			err = positions[slices.Index(ids, dependencyErr.Id)].wrap(err)
+		}
+		errs = append(errs, err)
+	}
+	return errs
+}
@@ -0,0 +1,55 @@
+package workflow
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	err := Validate("test_data/test.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test that all problems are reported at once with their position
+func TestValidateErrors(t *testing.T) {
+	err := Validate("test_data/test-invalid.yaml")
+	if err == nil {
+		t.Fatal("want errors, got nil")
+	}
+
+	for _, want := range []error{
+		WorkflowErrorInvalidTimeout,
+		WorkflowErrorInvalidVars,
+		WorkflowErrorInvalidWeight,
+		WorkflowErrorTaskMissingCommand,
+		WorkflowErrorUnreachableExits,
+		WorkflowErrorDuplicateId,
+	} {
+		if !errors.Is(err, want) {
+			t.Errorf("want %v, got %v", want, err)
+		}
+	}
+
+	lines := strings.Split(err.Error(), "\n")
+	want := []string{
+		"test_data/test-invalid.yaml:17:18: invalid timeout: invalid duration \"soon\"",
+		"test_data/test-invalid.yaml:3:3: invalid variables definition: invalid name \"2FA\"",
+		"test_data/test-invalid.yaml:7:9: invalid weight",
+		"test_data/test-invalid.yaml:10:9: task missing cmd",
+		"test_data/test-invalid.yaml:14:9: exits task is never run",
+		"test_data/test-invalid.yaml:11:5: group1: duplicate id",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("want\n%s\ngot\n%s", strings.Join(want, "\n"), err)
+	}
+}
+
+func TestValidateMissingFile(t *testing.T) {
+	err := Validate("test_data/does-not-exist.yaml")
+	if err == nil {
+		t.Fatal("want error for missing file")
+	}
+}
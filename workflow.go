@@ -8,17 +8,20 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/coder/websocket"
-	"github.com/coder/websocket/wsjson"
-	"gopkg.in/yaml.v3"
 )
 
 type Workflow struct {
@@ -27,10 +30,22 @@ type Workflow struct {
 	workflowPath string // Path to the workflow definition file
 	statusPath   string // Path to the status persistent state
 
-	ctx         context.Context
-	cancel      context.CancelFunc
-	currentTask *Task
-	ws          []*websocket.Conn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	active  bool    // Workflow is running
+	running []*Task // Currently running tasks
+
+	hookVars map[string]string // Variables exported to hooks
+	secrets  map[string]string // Values of secret variables, masked in the status
+
+	executors map[string]Executor       // Executors by task type
+	output    io.Writer                 // Standard output of tasks, os.Stdout if nil
+	providers map[string]SecretProvider // Secret providers by name
+	clients   *hub                      // Websocket and server-sent events clients
+	events    []Event                   // Last events, for clients resuming
+
+	authorizer    Authorizer               // Permissions of clients
+	acceptOptions *websocket.AcceptOptions // Options of websocket connections
 
 	sync.Mutex
 }
@@ -40,34 +55,76 @@ type Status struct {
 	// Definition is a copy of the original workflow definition
 	// For example, if you defined a multi step workflow and the original
 	// definition file is changed, this will not be updated.
-	Definition map[string]any `json:"definition"`
+	Definition *Definition `json:"definition"`
 
-	// Vars contains all the values for variables defined in the workflow
+	// Vars contains all the values for variables defined in the workflow, and
+	// the ones published by tasks with the `set` shell function. The values
+	// of secret variables are masked.
 	Vars map[string]string `json:"vars"`
 
 	// Groups contains all the groups defined in the workflow, which in turn
 	// contains all the tasks.
 	Groups []*Group `json:"groups"`
 
+	// OnSuccess, OnFailure and Finally contain the groups defined as hooks,
+	// run after Groups when they all succeeded, when any of them failed, and
+	// in any case.
+	OnSuccess []*Group `json:"onSuccess,omitempty"`
+	OnFailure []*Group `json:"onFailure,omitempty"`
+	Finally   []*Group `json:"finally,omitempty"`
+
 	Started  bool `json:"started"`  // Workflow has been started
 	Finished bool `json:"finished"` // Workflow has finished
 	Percent  int  `json:"percent"`  // Workflow progress in percent, assuming all tasks have weights defined
 
 	LastMessage string `json:"lastMessage"` // The last message returned by a task using `output`
 
-	CurrentGroup string `json:"currentGroup"` // Currently running group
-	CurrentTask  string `json:"currentTask"`  // Currently running task
+	CurrentGroup  string   `json:"currentGroup"`  // Last started group
+	CurrentGroups []string `json:"currentGroups"` // Currently running groups, there can be several when using depends_on
+	CurrentTask   string   `json:"currentTask"`   // Last started task
+	CurrentTasks  []string `json:"currentTasks"`  // Currently running tasks, there can be several in parallel groups
 
 	Error string `json:"error,omitempty"` // Last error
+
+	Env        map[string]string `json:"env,omitempty"`        // Environment variables of commands
+	InheritEnv *InheritEnv       `json:"inheritEnv,omitempty"` // Environment variables of the program inherited by commands
+
+	Timeout  Duration `json:"timeout,omitempty"` // Maximum duration of the workflow run
+	TimedOut bool     `json:"timedOut"`          // Workflow failed because a timeout expired
+
+	Warnings []string `json:"warnings,omitempty"` // Errors of tasks allowed to fail
+	Outcome  Outcome  `json:"outcome,omitempty"`  // Summary of the run, set once finished
+
+	Seq uint64 `json:"seq"` // Sequence number of the last event, see [Event]
 }
 
+// Outcome summarizes how a workflow run ended.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success" // All tasks succeeded
+	OutcomeWarning Outcome = "warning" // Succeeded, but some tasks allowed to fail did fail
+	OutcomeFailure Outcome = "failure" // A task failed
+	OutcomeAborted Outcome = "aborted" // Workflow was aborted
+	OutcomeTimeout Outcome = "timeout" // A timeout expired
+)
+
 // New returns a new Workflow with definition at definitionPath and status file
 // to be written at statusFilePath as well as a http.HandlerFunc for handling
-// websocket connections.
+// websocket connections, streaming the workflow [Event]s.
 func New(definitionFilePath string, statusFilePath string) (*Workflow, http.Handler, error) {
 	result := &Workflow{
 		workflowPath: definitionFilePath,
 		statusPath:   statusFilePath,
+		executors: map[string]Executor{
+			"shell": shellExecutor{},
+			"exec":  execExecutor{},
+		},
+		providers: map[string]SecretProvider{
+			"env":  envProvider{},
+			"file": fileProvider{dir: path.Dir(definitionFilePath)},
+		},
+		clients: newHub(hubQueueSize, hubWriteTimeout),
 	}
 
 	// If a status file exists, probably from a previous run, load it
@@ -77,10 +134,11 @@ func New(definitionFilePath string, statusFilePath string) (*Workflow, http.Hand
 	b, err := os.ReadFile(result.statusPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			err := result.initialize()
+			status, err := newStatus(result.workflowPath)
 			if err != nil {
 				return nil, nil, err
 			}
+			result.initialize(status)
 		} else {
 			return nil, nil, err
 		}
@@ -93,34 +151,50 @@ func New(definitionFilePath string, statusFilePath string) (*Workflow, http.Hand
 	}
 
 	// Create websocket handler function that promotes the request to a
-	// websocket and sends current status to it.
+	// websocket and sends current status to it, or the events missed since
+	// the sequence number in the since query parameter, and processes the
+	// commands it sends.
 	websocketHandlerFunc := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn, err := websocket.Accept(w, r, nil)
+		// Commands are refused by default
+		permission := result.authorize(r, PermissionRead)
+		if permission == PermissionNone {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		since, err := parseSince(r)
 		if err != nil {
-			slog.Error("unable to create websocket", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		ctx := conn.CloseRead(r.Context())
 
-		result.ws = append(result.ws, conn)
+		result.Lock()
+		opts := result.acceptOptions
+		result.Unlock()
+		conn, err := websocket.Accept(w, r, opts)
+		if err != nil {
+			slog.Error("unable to create websocket", "error", err)
+			return
+		}
 
-		err = result.writeSockets()
+		// Register the client under the lock, so that it doesn't miss any
+		// event, nor gets one twice
+		result.Lock()
+		messages, err := result.replay(since)
 		if err != nil {
+			result.Unlock()
 			slog.Error("unable to write initial status to websocket", "error", err)
+			conn.Close(websocket.StatusInternalError, "")
 			return
 		}
+		c := result.clients.add(websocketSink{conn}, messages...)
+		result.Unlock()
 
-		<-ctx.Done()
+		// Until the client is gone
+		result.readCommands(r.Context(), conn, c, permission)
 
-		conn.Close(websocket.StatusNormalClosure, "")
-
-		// Remove connection from list
-		for i := range result.ws {
-			if result.ws[i] == conn {
-				result.ws = append(result.ws[:i], result.ws[i+1:]...)
-				break
-			}
-		}
+		result.clients.remove(c, websocket.StatusNormalClosure, "")
+		<-c.done
 	})
 
 	return result, websocketHandlerFunc, nil
@@ -132,325 +206,1185 @@ type contextKey struct {
 
 func (k *contextKey) String() string { return "workflow context value " + k.name }
 
-var contextKeyVars = contextKey{"vars"}
+var contextKeyEnv = contextKey{"env"}
+
+// newStatus returns the initial status of the workflow defined in the file at
+// definitionPath.
+func newStatus(definitionPath string) (*Status, error) {
+	// Read workflow definition from YAML
+	definition, err := loadDefinition(definitionPath)
+	if err != nil {
+		return nil, err
+	}
 
-func (w *Workflow) initialize() error {
-	w.Status = Status{
-		Definition: map[string]any{},
+	if errs := definition.check(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
-	// Read workflow definition from YAML
-	f, err := os.ReadFile(w.workflowPath)
+	result := &Status{Definition: definition}
+
+	result.Groups, err = loadGroups(definition.Groups)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = yaml.Unmarshal(f, w.Status.Definition)
+	result.OnSuccess, err = loadHooks(definition.OnSuccess)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	result.OnFailure, err = loadHooks(definition.OnFailure)
+	if err != nil {
+		return nil, err
+	}
+	result.Finally, err = loadHooks(definition.Finally)
+	if err != nil {
+		return nil, err
 	}
 
-	groups_definition, ok := w.Status.Definition["groups"].([]any)
-	if !ok {
-		return WorkflowErrorNoGroups
+	result.Env = definition.Env
+	result.InheritEnv = definition.InheritEnv
+	result.Timeout = definition.Timeout
+
+	return result, nil
+}
+
+// initialize sets the workflow to status, discarding the state of the
+// previous run. Caller must hold the lock.
+func (w *Workflow) initialize(status *Status) {
+	// Sequence numbers keep increasing, clients resuming from a previous run
+	// get a snapshot
+	status.Seq = w.Status.Seq
+	w.Status = *status
+	w.hookVars = nil
+	w.secrets = nil
+	w.events = nil
+}
+
+// VarError is returned when a variable can't be initialized. It names the
+// variable, and carries the standard error of its command, if any.
+type VarError struct {
+	Name   string // Name of the variable
+	Stderr string // Standard error of the command, with secrets masked
+	Err    error
+}
+
+func (e *VarError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("failed to initialize variable %s: %s", e.Name, e.Err)
 	}
+	return fmt.Sprintf("failed to initialize variable %s: %s: %s", e.Name, e.Err, e.Stderr)
+}
 
-	w.Status.Groups, err = loadGroups(groups_definition)
+func (e *VarError) Unwrap() error { return e.Err }
+
+// loadVars initializes the variables of definitions that have no value yet,
+// with inputs, their literal value, their command or their secret provider.
+// Variables are initialized in declaration order, except that the variables
+// referenced by a command are initialized before it, and commands have the
+// variables initialized so far in their environment.
+//
+// Secrets, and variables whose value contains a secret, are not written to
+// the status file, so they are initialized again when a workflow is
+// continued.
+func (w *Workflow) loadVars(ctx context.Context, definitions VarDefinitions, inputs map[string]string) error {
+	ids, dependsOn := definitions.dependencies()
+	g, err := newGraph(ids, dependsOn, false)
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-func (w *Workflow) loadVars(definitions map[string]any) (map[string]string, error) {
-	result := map[string]string{}
+	for _, i := range g.order() {
+		def := definitions[i]
+		k := def.Name
+		w.Lock()
+		v, ok := w.Status.Vars[k]
+		if def.secret() || v == secretMask {
+			_, ok = w.secrets[k]
+		}
+		w.Unlock()
+		input, given := inputs[k]
+		if ok && !given {
+			continue
+		}
 
-	for k, v := range definitions {
-		command, ok := v.(string)
-		if !ok {
-			return nil, WorkflowErrorInvalidVars
+		var value string
+		switch {
+		case given:
+			value = input
+		case def.Value != "":
+			value = def.Value
+		case def.From != "":
+			value, err = w.resolve(ctx, def.From)
+			if err != nil {
+				slog.Error("error while resolving secret", "var", k, "error", err)
+				return &VarError{Name: k, Err: err}
+			}
+		case def.Cmd != "":
+			cmd := exec.CommandContext(ctx, "sh", "-c", def.Cmd)
+			cmd.Dir = path.Dir(w.workflowPath)
+			cmd.Env = w.environ(nil, nil)
+
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			cmd.Cancel = func() error { return killGroup(cmd) }
+
+			out, err := cmd.Output()
+			if err := contextError(ctx); err != nil {
+				slog.Warn("variable interrupted", "var", k, "error", err)
+				return &VarError{Name: k, Err: err}
+			}
+			if err != nil {
+				varErr := &VarError{Name: k, Err: err}
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					w.Lock()
+					varErr.Stderr = w.redact(strings.TrimSpace(string(exitErr.Stderr)))
+					w.Unlock()
+				}
+				slog.Error("error while initializing variables", "var", k, "error", err, "stderr", varErr.Stderr)
+				return varErr
+			}
+			value = strings.TrimSpace(string(out))
+		case def.Required:
+			return &VarError{Name: k, Err: fmt.Errorf("%w: missing required %s", WorkflowErrorInvalidInput, k)}
+		default:
+			value = def.Default
 		}
-		cmd := exec.Command("sh", "-c", command)
-		cmd.Dir = path.Dir(w.workflowPath)
 
-		out, err := cmd.Output()
-		if err != nil {
-			slog.Error("error while initializing variables", "error", err)
-			return nil, fmt.Errorf("failed to initialize variable %s: %w", k, err)
+		// Inputs that are not required may be left empty
+		if value != "" || !def.input() {
+			err := def.validate(value)
+			if err != nil {
+				return &VarError{Name: k, Err: fmt.Errorf("%w: %w", WorkflowErrorInvalidInput, err)}
+			}
 		}
-		value := strings.TrimSpace(string(out))
-		result[k] = value
+
+		w.Lock()
+		w.setVar(k, value)
+		w.Unlock()
 	}
 
-	return result, nil
+	return nil
 }
 
-func loadGroups(definitions []any) ([]*Group, error) {
+func loadGroups(definitions []*GroupDefinition) ([]*Group, error) {
 	result := []*Group{}
 	for i := range definitions {
-		group, err := newGroup(definitions[i].(map[string]any))
+		group, err := newGroup(definitions[i])
 		if err != nil {
 			return nil, err
 		}
 		result = append(result, group)
 	}
-
 	return result, nil
 }
 
-// Start starts the workflow execution and returns any error encountered
-func (w *Workflow) Start() error {
-	// Close the websocket when done
-	defer func() {
-		for _, ws := range w.ws {
-			if err := ws.Close(websocket.StatusNormalClosure, ""); err != nil {
-				slog.Error("error closing websocket", "error", err)
-			}
-		}
-	}()
+// loadHooks loads the groups of a hook definition, which is optional.
+func loadHooks(definitions []*GroupDefinition) ([]*Group, error) {
+	if definitions == nil {
+		return nil, nil
+	}
+	return loadGroups(definitions)
+}
 
-	// Load vars values
-	var err error
-	if w.Status.Vars == nil {
-		if vars, ok := w.Status.Definition["vars"]; ok {
-			vars, ok := vars.(map[string]any)
-			if !ok {
-				return WorkflowErrorInvalidVars
-			}
-			w.Status.Vars, err = w.loadVars(vars)
-			if err != nil {
-				return err
+// groupsGraph returns the dependency graph of groups.
+func groupsGraph(groups []*Group) (*graph, error) {
+	ids := []string{}
+	dependsOn := [][]string{}
+	for _, group := range groups {
+		ids = append(ids, group.Id)
+		dependsOn = append(dependsOn, group.DependsOn)
+	}
+	return newGraph(ids, dependsOn, true)
+}
+
+// RegisterExecutor registers executor for tasks of type name, replacing any
+// executor previously registered for this type, including built-in ones.
+func (w *Workflow) RegisterExecutor(name string, executor Executor) {
+	w.Lock()
+	defer w.Unlock()
+	w.executors[name] = executor
+}
+
+// SetOutput sets the writer receiving the standard output of tasks, and the
+// messages they send, instead of os.Stdout. It must be safe for concurrent
+// use, like an [os.File]. Tasks already running keep writing to the previous
+// one.
+func (w *Workflow) SetOutput(out io.Writer) {
+	w.Lock()
+	defer w.Unlock()
+	w.output = out
+}
+
+// executor returns the executor for tasks of type name.
+func (w *Workflow) executor(name string) (Executor, error) {
+	if name == "" {
+		name = "shell"
+	}
+	w.Lock()
+	defer w.Unlock()
+	executor, ok := w.executors[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", WorkflowErrorUnknownTaskType, name)
+	}
+	return executor, nil
+}
+
+// checkExecutors returns an error if a task has a type without registered
+// executor.
+func (w *Workflow) checkExecutors() error {
+	for _, groups := range [][]*Group{w.Status.Groups, w.Status.OnSuccess, w.Status.OnFailure, w.Status.Finally} {
+		for _, group := range groups {
+			for _, task := range group.Tasks {
+				if _, err := w.executor(task.Type); err != nil {
+					return fmt.Errorf("task %s: %w", task.Id, err)
+				}
 			}
 		}
 	}
+	return nil
+}
 
-	groups := w.Status.Groups
+// Start starts the workflow execution and returns any error encountered. It
+// returns [WorkflowErrorRunning] if the workflow is already running.
+func (w *Workflow) Start() error {
+	return w.StartWithInputs(nil)
+}
 
-	// Load group skip values
-	for g := range groups {
-		group := groups[g]
+// StartWithInputs starts the workflow execution like [Start], with inputs
+// giving the value of variables by name. It returns [WorkflowErrorInvalidInput]
+// if an input is not a declared variable, if its value doesn't match the type
+// of the variable, or if a required input is missing.
+func (w *Workflow) StartWithInputs(inputs map[string]string) error {
+	err := w.checkInputs(inputs)
+	if err != nil {
+		return err
+	}
+
+	err = w.begin()
+	if err != nil {
+		return err
+	}
+	defer w.end()
+
+	return w.start(inputs)
+}
 
-		if group.skip_cmd == "" {
+// checkInputs returns an error if inputs can't start the workflow.
+func (w *Workflow) checkInputs(inputs map[string]string) error {
+	w.Lock()
+	defer w.Unlock()
+
+	var definitions VarDefinitions
+	if w.Status.Definition != nil {
+		definitions = w.Status.Definition.Vars
+	}
+
+	errs := []error{}
+	for _, name := range slices.Sorted(maps.Keys(inputs)) {
+		def := definitions.find(name)
+		if def == nil {
+			errs = append(errs, fmt.Errorf("%w: unknown variable %s", WorkflowErrorInvalidInput, name))
 			continue
 		}
+		if err := def.validate(inputs[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", WorkflowErrorInvalidInput, err))
+		}
+	}
 
-		// Check if group should be skipped
-		cmd := exec.Command("bash", "-c", group.skip_cmd)
-
-		// Setup environment with variables values
-		for k, v := range w.Status.Vars {
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	// Required inputs are only missing when starting from scratch, or for
+	// secrets when continuing
+	for _, def := range definitions {
+		_, given := inputs[def.Name]
+		_, known := w.Status.Vars[def.Name]
+		if def.secret() {
+			_, known = w.secrets[def.Name]
+		}
+		if def.Required && !given && !known {
+			errs = append(errs, fmt.Errorf("%w: missing required %s", WorkflowErrorInvalidInput, def.Name))
 		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// begin marks the workflow as running, unless it already is.
+func (w *Workflow) begin() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.active {
+		return WorkflowErrorRunning
+	}
+	w.active = true
+
+	// The context exists as soon as the workflow runs, so that it can be
+	// aborted right away
+	ctx := context.Background()
+	if w.Status.Timeout > 0 {
+		w.ctx, w.cancel = context.WithTimeout(ctx, time.Duration(w.Status.Timeout))
+	} else {
+		w.ctx, w.cancel = context.WithCancel(ctx)
+	}
+	return nil
+}
 
-		// Commands are always executed in the workflow directory
-		cmd.Dir = path.Dir(w.workflowPath)
+// end marks the workflow as not running anymore.
+func (w *Workflow) end() {
+	w.Lock()
+	defer w.Unlock()
+	w.active = false
+}
 
-		err = cmd.Run()
-		if err == nil {
-			group.Skip = true
+// isRunning returns true if the workflow is running.
+func (w *Workflow) isRunning() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.active
+}
+
+// start runs the workflow with inputs, once marked as running.
+func (w *Workflow) start(inputs map[string]string) (err error) {
+	// Close the websockets and event streams when done
+	defer w.clients.closeAll()
+
+	// Fail early if executors are missing
+	err = w.checkExecutors()
+	if err != nil {
+		return err
+	}
+
+	// Load vars values
+	if w.Status.Definition != nil {
+		err = w.loadVars(w.ctx, w.Status.Definition.Vars, inputs)
+		if err != nil {
+			return err
 		}
 	}
 
-	w.ctx, w.cancel = context.WithCancel(context.WithValue(context.Background(), contextKeyVars, w.Status.Vars))
+	groups := w.Status.Groups
 
+	w.Lock()
 	w.Status.Started = true
+	w.emit(Event{Type: EventWorkflowStarted})
+	w.Unlock()
 	err = w.writeStatus()
 	if err != nil {
 		return err
 	}
 
 	defer func() {
+		w.Lock()
 		w.Status.Finished = true
+		w.Status.Outcome = w.outcome(err)
+		w.emit(Event{Type: EventWorkflowFinished, Outcome: w.Status.Outcome, Error: w.Status.Error})
+		w.Unlock()
 		_ = w.writeStatus()
-		_ = w.writeSockets()
 		os.Remove(w.statusPath)
 	}()
 
-	seeking := false
-	if w.Status.CurrentTask != "" {
-		seeking = true
+	slog.Debug("starting workflow", "status", w.Status, "groups", groups)
+
+	err = w.runGroups(w.ctx, groups)
+	if err == nil {
+		// Handle cancellation and timeout
+		err = w.interrupted(w.ctx, &w.Status.Error)
+	}
+	if errors.Is(err, WorkflowErrorTimeout) {
+		w.Lock()
+		w.Status.TimedOut = true
+		w.Unlock()
 	}
 
-	slog.Debug("starting workflow", "seeking", seeking, "status", w.Status, "groups", groups)
-	for _, group := range groups {
-		if group.Skip {
-			slog.Debug("normal skipping group", "group", group.Id)
-			continue
+	return w.runHooks(err)
+}
+
+// runGroups runs groups according to their dependencies, and returns when
+// all of them are done.
+func (w *Workflow) runGroups(ctx context.Context, groups []*Group) error {
+	g, err := groupsGraph(groups)
+	if err != nil {
+		return err
+	}
+
+	// Skipped groups and groups finished during a previous run are not run
+	// again
+	done := func(i int) bool {
+		if groups[i].Skip {
+			slog.Debug("normal skipping group", "group", groups[i].Id)
+			return true
 		}
+		if groups[i].Finished {
+			slog.Debug("skipping group (already finished)", "group", groups[i].Id)
+			return true
+		}
+		return false
+	}
 
-		if seeking && group.Id != w.Status.CurrentGroup {
-			for i := range group.Tasks {
-				group.Started = true
-				group.Finished = true
-				group.Tasks[i].Started = true
-				group.Tasks[i].Finished = true
-			}
-			slog.Debug("skipping group (not current group)", "group", group.Id)
-			continue
+	run := func(i int) error {
+		return w.runGroup(ctx, groups[i])
+	}
+
+	blocked := func(i int) {
+		slog.Debug("group blocked by a failed dependency", "group", groups[i].Id)
+		w.Lock()
+		groups[i].Blocked = true
+		w.emit(w.groupEvent(EventGroupBlocked, groups[i]))
+		w.Unlock()
+	}
+
+	return g.schedule(ctx, len(groups), done, run, blocked)
+}
+
+// runHooks runs the on_success or on_failure hooks depending on err, the
+// error returned by the workflow groups, then the finally hooks. The returned
+// error contains err and any error returned by hooks.
+//
+// Hooks are run with their own context so they still run after the workflow
+// is aborted or timed out, and the triggering error is available to them in
+// the WORKFLOW_ERROR environment variable.
+func (w *Workflow) runHooks(err error) error {
+	hooks := [][]*Group{w.Status.OnSuccess, w.Status.Finally}
+	if err != nil {
+		hooks[0] = w.Status.OnFailure
+	}
+	if len(hooks[0]) == 0 && len(hooks[1]) == 0 {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w.Lock()
+	w.cancel = cancel
+	w.hookVars = map[string]string{
+		"WORKFLOW_ERROR":   "",
+		"WORKFLOW_OUTCOME": string(w.outcome(err)),
+	}
+	if err != nil {
+		w.hookVars["WORKFLOW_ERROR"] = err.Error()
+	}
+	w.Unlock()
+
+	errs := []error{err}
+	for _, groups := range hooks {
+		slog.Debug("running hooks", "groups", groups)
+		hookErr := w.runGroups(ctx, groups)
+		if hookErr != nil {
+			slog.Error("hook failed", "error", hookErr)
+			errs = append(errs, hookErr)
 		}
-		w.Status.CurrentGroup = group.Id
-		group.Started = true
-		for _, task := range group.Tasks {
-			slog.Debug("starting task", "task", task)
-			// Handle cancellation
-			if err := w.ctx.Err(); err != nil {
-				slog.Warn("workflow aborted", "error", err)
-				task.Error = err.Error()
-				group.Error = err.Error()
-				w.Status.Error = err.Error()
-				return nil
-			}
+	}
 
-			if seeking && task.Id != w.Status.CurrentTask {
-				task.Started = true
-				task.Finished = true
-				slog.Debug("skipping task (not current task)", "task", task)
-				continue
-			}
+	return errors.Join(errs...)
+}
 
-			if seeking {
-				seeking = false
-				if task.Exits {
-					task.Started = true
-					task.Finished = true
-					continue
-				}
+// runGroup runs the tasks of group according to their dependencies, and
+// returns when all of them are done.
+func (w *Workflow) runGroup(ctx context.Context, group *Group) error {
+	g, err := group.graph()
+	if err != nil {
+		return err
+	}
+
+	if group.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(group.Timeout))
+		defer cancel()
+	}
+
+	// Check if group should be skipped
+	skip, err := w.skipGroup(ctx, group)
+	if err != nil {
+		w.Lock()
+		group.Error = err.Error()
+		group.TimedOut = errors.Is(err, WorkflowErrorTimeout)
+		w.Status.Error = err.Error()
+		e := w.groupEvent(EventGroupFinished, group)
+		e.Outcome, e.Error = outcomeOf(ctx, err, false), group.Error
+		w.emit(e)
+		w.Unlock()
+		return err
+	}
+	if skip {
+		slog.Debug("skipping group", "group", group.Id)
+		w.Lock()
+		group.Skip = true
+		w.emit(w.groupEvent(EventGroupSkipped, group))
+		w.Unlock()
+		_ = w.writeStatus()
+		return nil
+	}
+
+	w.Lock()
+	w.Status.CurrentGroup = group.Id
+	w.Status.CurrentGroups = append(w.Status.CurrentGroups, group.Id)
+	group.Started = true
+	w.emit(w.groupEvent(EventGroupStarted, group))
+	w.Unlock()
+
+	defer func() {
+		w.Lock()
+		w.Status.CurrentGroups = slices.DeleteFunc(w.Status.CurrentGroups, func(id string) bool { return id == group.Id })
+		w.Unlock()
+	}()
+
+	// Skipped tasks and tasks finished during a previous run are not run
+	// again, and a task that
+	// exits the program is considered finished when the workflow is continued
+	done := func(i int) bool {
+		task := group.Tasks[i]
+		if task.Skip {
+			slog.Debug("normal skipping task", "task", task.Id)
+			return true
+		}
+		if task.Finished {
+			slog.Debug("skipping task (already finished)", "task", task.Id)
+			return true
+		}
+		if task.Started && task.Exits {
+			slog.Debug("skipping task (exited previous run)", "task", task.Id)
+			w.Lock()
+			task.Finished = true
+			w.Unlock()
+			return true
+		}
+		return false
+	}
+
+	run := func(i int) error {
+		return w.runTask(ctx, group, group.Tasks[i])
+	}
+
+	blocked := func(i int) {
+		slog.Debug("task blocked by a failed dependency", "task", group.Tasks[i].Id)
+		w.Lock()
+		group.Tasks[i].Blocked = true
+		w.emit(w.taskEvent(EventTaskBlocked, group, group.Tasks[i]))
+		w.Unlock()
+	}
+
+	err = g.schedule(ctx, group.concurrency(), done, run, blocked)
+	if err == nil {
+		// Handle cancellation and timeout
+		err = w.interrupted(ctx, &group.Error)
+	}
+	if err != nil {
+		w.Lock()
+		if errors.Is(err, WorkflowErrorTimeout) {
+			group.TimedOut = true
+		}
+		e := w.groupEvent(EventGroupFinished, group)
+		e.Outcome, e.Error = outcomeOf(ctx, err, false), group.Error
+		w.emit(e)
+		w.Unlock()
+		return err
+	}
+
+	w.Lock()
+	group.Finished = true
+	e := w.groupEvent(EventGroupFinished, group)
+	e.Outcome, e.Error = outcomeOf(ctx, nil, group.Warning), group.Error
+	w.emit(e)
+	w.Unlock()
+	slog.Debug("group ended", "group", group.Id)
+
+	_ = w.writeStatus()
+
+	return nil
+}
+
+// runTask runs a single task of group, retrying it according to its retry
+// policy, and updating the status with messages sent by the task.
+func (w *Workflow) runTask(ctx context.Context, group *Group, task *Task) error {
+	// Check if task should be skipped
+	skip, err := w.skipTask(ctx, group, task)
+	if err != nil {
+		w.Lock()
+		task.Error = err.Error()
+		task.TimedOut = errors.Is(err, WorkflowErrorTimeout)
+		group.Error = err.Error()
+		w.Status.Error = err.Error()
+		e := w.taskEvent(EventTaskFinished, group, task)
+		e.Outcome, e.Error = outcomeOf(ctx, err, false), task.Error
+		w.emit(e)
+		w.Unlock()
+		return err
+	}
+	if skip {
+		slog.Debug("skipping task", "task", task.Id)
+		w.Lock()
+		task.Skip = true
+		w.emit(w.taskEvent(EventTaskSkipped, group, task))
+		w.Unlock()
+		_ = w.writeStatus()
+		return nil
+	}
+
+	slog.Debug("starting task", "task", task)
+
+	w.Lock()
+	w.Status.CurrentTask = task.Id
+	w.running = append(w.running, task)
+	w.Status.CurrentTasks = w.runningIds()
+	task.Started = true
+	task.Attempts = nil
+	w.Unlock()
+
+	defer func() {
+		w.Lock()
+		w.running = slices.DeleteFunc(w.running, func(t *Task) bool { return t == task })
+		w.Status.CurrentTasks = w.runningIds()
+		w.Unlock()
+	}()
+
+	delay := time.Duration(task.RetryDelay)
+	for attempt := 1; ; attempt++ {
+		err = w.runAttempt(ctx, group, task, attempt)
+		if err == nil || attempt > task.Retries || ctx.Err() != nil {
+			break
+		}
+
+		slog.Warn("task failed, retrying", "task", task.Id, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		delay = time.Duration(float64(delay) * task.backoff())
+	}
+
+	// Failures of tasks allowed to fail are only recorded as warnings, unless
+	// the workflow or group was interrupted
+	tolerated := err != nil && ctx.Err() == nil && (task.AllowFailure || group.ContinueOnError)
+
+	if err != nil && !tolerated {
+		w.Lock()
+		group.Error = task.Error
+		w.Status.Error = task.Error
+		e := w.taskEvent(EventTaskFinished, group, task)
+		e.ExitCode, e.Error, e.Outcome = task.ExitCode, task.Error, outcomeOf(ctx, err, false)
+		w.emit(e)
+		w.Unlock()
+		_ = w.writeStatus()
+		return err
+	}
+
+	w.Lock()
+	if tolerated {
+		slog.Warn("task failed, continuing", "task", task.Id, "error", err)
+		task.Warning = true
+		group.Warning = true
+		group.Error = task.Error
+		w.Status.Warnings = append(w.Status.Warnings, fmt.Sprintf("%s/%s: %s", group.Id, task.Id, task.Error))
+	}
+	if !task.Exits || tolerated {
+		task.Finished = true
+	}
+	e := w.taskEvent(EventTaskFinished, group, task)
+	e.ExitCode, e.Error, e.Outcome = task.ExitCode, task.Error, outcomeOf(ctx, nil, tolerated)
+	w.emit(e)
+	w.Unlock()
+	slog.Debug("task ended", "task", task)
+
+	_ = w.writeStatus()
+
+	if task.Exits && !tolerated {
+		os.Exit(128)
+	}
+
+	return nil
+}
+
+// skip runs skip_cmd with environment env and returns true if it succeeded,
+// meaning the group or task it is defined on should be skipped. skip_cmd is
+// killed when ctx is done, and the reason is returned as an error.
+func (w *Workflow) skip(ctx context.Context, skip_cmd string, env []string) (bool, error) {
+	if skip_cmd == "" {
+		return false, nil
+	}
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", skip_cmd)
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return killGroup(cmd) }
+
+	// Commands are always executed in the workflow directory
+	cmd.Dir = path.Dir(w.workflowPath)
+
+	err := cmd.Run()
+	if err := contextError(ctx); err != nil {
+		slog.Warn("skip_cmd interrupted", "error", err)
+		return false, err
+	}
+	return err == nil, nil
+}
+
+// skipGroup returns true if group should be skipped according to its `when`
+// expression and skip_cmd.
+func (w *Workflow) skipGroup(ctx context.Context, group *Group) (bool, error) {
+	if group.When != "" {
+		run, err := w.when(group, group.When)
+		if err != nil || !run {
+			return !run, err
+		}
+	}
+	return w.skip(ctx, group.SkipCmd, w.environ(group, nil))
+}
+
+// skipTask returns true if task should be skipped according to its `when`
+// expression and skip_cmd.
+func (w *Workflow) skipTask(ctx context.Context, group *Group, task *Task) (bool, error) {
+	if task.When != "" {
+		run, err := w.when(group, task.When)
+		if err != nil || !run {
+			return !run, err
+		}
+	}
+	return w.skip(ctx, task.SkipCmd, w.environ(group, task))
+}
+
+// when evaluates expression src in the context of group.
+func (w *Workflow) when(group *Group, src string) (bool, error) {
+	e, err := compileExpr(src)
+	if err != nil {
+		return false, err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	return e.eval(w.resolver(group))
+}
+
+// resolver returns a resolver for `when` expressions evaluated in group,
+// giving access to variables and to the state of groups and tasks. Caller
+// must hold the lock while evaluating.
+func (w *Workflow) resolver(group *Group) resolver {
+	return func(path []string) (any, error) {
+		switch {
+		case len(path) == 1:
+			return w.typedValue(path[0]), nil
+		case len(path) == 2 && path[0] == "vars":
+			return w.typedValue(path[1]), nil
+		case len(path) == 3 && path[0] == "tasks":
+			task := w.findTask(group, path[1])
+			if task == nil {
+				return nil, fmt.Errorf("unknown task %s", path[1])
 			}
-			w.Status.CurrentTask = task.Id
-			w.currentTask = task
-			err = w.writeStatus()
-			if err != nil {
-				return err
+			switch path[2] {
+			case "exit_code":
+				return task.ExitCode, nil
+			case "attempt":
+				return task.Attempt, nil
 			}
+			return state(path[2], task.Started, task.Finished, task.Skip, task.Blocked, task.Warning, task.TimedOut, task.Error)
+		case len(path) == 3 && path[0] == "groups":
+			i := slices.IndexFunc(w.Status.Groups, func(g *Group) bool { return g.Id == path[1] })
+			if i < 0 {
+				return nil, fmt.Errorf("unknown group %s", path[1])
+			}
+			g := w.Status.Groups[i]
+			return state(path[2], g.Started, g.Finished, g.Skip, g.Blocked, g.Warning, g.TimedOut, g.Error)
+		}
+		return nil, fmt.Errorf("unknown reference %s", strings.Join(path, "."))
+	}
+}
 
-			wfout, err := task.wfoutPipe()
-			if err != nil {
-				return err
+// typedValue returns the value of variable name for `when` expressions, as a
+// boolean or an integer according to its type. Caller must hold the lock.
+func (w *Workflow) typedValue(name string) any {
+	value := w.value(name)
+	if w.Status.Definition == nil {
+		return value
+	}
+	def := w.Status.Definition.Vars.find(name)
+	if def == nil {
+		return value
+	}
+	switch def.Type {
+	case "bool":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case "int":
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return value
+}
+
+// state returns the state field of a group or task for `when` expressions.
+func state(field string, started, finished, skipped, blocked, warning, timedOut bool, err string) (any, error) {
+	switch field {
+	case "started":
+		return started, nil
+	case "finished":
+		return finished, nil
+	case "skipped":
+		return skipped, nil
+	case "blocked":
+		return blocked, nil
+	case "warning":
+		return warning, nil
+	case "timed_out":
+		return timedOut, nil
+	case "error":
+		return err, nil
+	}
+	return nil, fmt.Errorf("unknown field %s", field)
+}
+
+// findTask returns the task with id, looking into group first and then into
+// all groups in order.
+func (w *Workflow) findTask(group *Group, id string) *Task {
+	for _, g := range append([]*Group{group}, w.Status.Groups...) {
+		for _, task := range g.Tasks {
+			if task.Id == id {
+				return task
 			}
+		}
+	}
+	return nil
+}
 
-			go func() {
-				defer wfout.Close()
-				rd := bufio.NewReader(wfout)
-				for {
-					s, err := rd.ReadString('\n')
-					if err != nil {
-						if err == io.EOF || errors.Is(err, os.ErrClosed) {
-							slog.Debug("wfout closed, exiting reader loop")
-							break
-						}
-						slog.Error("error while reading fifo", "error", err)
-						break
-					}
-
-					switch {
-					case strings.HasPrefix(s, "progress:: "):
-						s = strings.TrimPrefix(s, "progress:: ")
-						s = strings.TrimSpace(s)
-						progress, err := strconv.ParseFloat(s, 64)
-						if err != nil {
-							slog.Error("unable to parse progress", "error", err)
-							continue
-						}
-						w.currentTask.Percent = progress
-					case strings.HasPrefix(s, "output:: "):
-						s = strings.TrimPrefix(s, "output:: ")
-						s = strings.TrimSpace(s)
-
-						w.Status.LastMessage = s
-						group.LastMessage = s
-					case strings.HasPrefix(s, "error:: "):
-						s = strings.TrimPrefix(s, "error:: ")
-						s = strings.TrimSpace(s)
-
-						task.Error = s
-						group.Error = s
-						w.Status.Error = s
-					}
-
-					err = w.writeStatus()
-					if err != nil {
-						slog.Error("unable to write status", "error", err)
-					}
-					err = w.writeSockets()
-					if err != nil {
-						slog.Error("unable to write status to socket", "error", err)
-					}
-				}
-			}()
-			task.Started = true
+// runAttempt runs task once and records the outcome of the attempt in its
+// status.
+func (w *Workflow) runAttempt(ctx context.Context, group *Group, task *Task, attempt int) error {
+	wfout, err := task.wfoutPipe()
+	if err != nil {
+		return err
+	}
 
-			slog.Debug("running task", "task", task)
-			err = task.run(w.ctx, path.Dir(w.workflowPath))
+	w.Lock()
+	task.Attempt = attempt
+	task.Percent = 0
+	task.Error = ""
+	task.TimedOut = false
+	task.Prompt = nil
+	task.answers = make(chan string, 1)
+	task.redact = w.redactMessage
+	task.output = w.output
+	w.emit(w.taskEvent(EventTaskStarted, group, task))
+	w.Unlock()
 
+	err = w.writeStatus()
+	if err != nil {
+		return err
+	}
+
+	// Read messages sent by the task until it closes wfout
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.readMessages(group, task, wfout)
+	}()
+
+	slog.Debug("running task", "task", task, "attempt", attempt)
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(task.Timeout))
+		defer cancel()
+	}
+
+	// Variables published by previous tasks are exported to the task, with
+	// its environment
+	ctx = context.WithValue(ctx, contextKeyEnv, w.environ(group, task))
+
+	executor, err := w.executor(task.Type)
+	if err != nil {
+		return err
+	}
+
+	err = task.run(ctx, path.Dir(w.workflowPath), executor)
+
+	<-done
+
+	w.Lock()
+	task.Prompt = nil
+	task.ExitCode = exitCode(err)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = WorkflowErrorTimeout
+		task.TimedOut = true
+	}
+	if err != nil && task.Error == "" {
+		task.Error = err.Error()
+	}
+	task.Attempts = append(task.Attempts, Attempt{
+		Number:   attempt,
+		ExitCode: task.ExitCode,
+		Error:    task.Error,
+	})
+	e := w.taskEvent(EventAttemptFinished, group, task)
+	e.ExitCode, e.Error = task.ExitCode, task.Error
+	w.emit(e)
+	w.Unlock()
+
+	return err
+}
+
+// varNameRegexp matches valid variable names for the `set` shell function.
+var varNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// readMessages parses messages sent by task through the `output`, `progress`,
+// `set`, `prompt` and `error` shell functions and updates the status
+// accordingly.
+func (w *Workflow) readMessages(group *Group, task *Task, wfout io.ReadCloser) {
+	defer wfout.Close()
+	rd := bufio.NewReader(wfout)
+	for {
+		s, err := rd.ReadString('\n')
+		if err != nil {
+			if err == io.EOF || errors.Is(err, os.ErrClosed) {
+				slog.Debug("wfout closed, exiting reader loop")
+				break
+			}
+			slog.Error("error while reading fifo", "error", err)
+			break
+		}
+
+		w.Lock()
+		switch {
+		case strings.HasPrefix(s, "progress:: "):
+			s = strings.TrimPrefix(s, "progress:: ")
+			s = strings.TrimSpace(s)
+			progress, err := strconv.ParseFloat(s, 64)
 			if err != nil {
-				if task.Error == "" {
-					task.Error = err.Error()
-					group.Error = err.Error()
-					w.Status.Error = err.Error()
-					w.Status.Finished = true
-				}
-				_ = w.writeStatus()
-				_ = w.writeSockets()
-				return err
+				slog.Error("unable to parse progress", "error", err)
+				w.Unlock()
+				continue
 			}
-			if !task.Exits {
-				task.Finished = true
+			task.Percent = progress
+			e := w.taskEvent(EventProgress, group, task)
+			e.Progress = progress
+			w.emit(e)
+		case strings.HasPrefix(s, "output:: "):
+			s = strings.TrimPrefix(s, "output:: ")
+			s = w.redact(strings.TrimSpace(s))
+
+			w.Status.LastMessage = s
+			group.LastMessage = s
+			task.LastMessage = s
+			e := w.taskEvent(EventOutput, group, task)
+			e.Message = s
+			w.emit(e)
+		case strings.HasPrefix(s, "set:: "):
+			s = strings.TrimPrefix(s, "set:: ")
+			s = strings.TrimSuffix(s, "\n")
+
+			name, value, _ := strings.Cut(s, " ")
+			if !varNameRegexp.MatchString(name) {
+				slog.Error("invalid variable name", "task", task.Id, "name", name)
+				w.Unlock()
+				continue
+			}
+			e := w.taskEvent(EventVar, group, task)
+			e.Name, e.Value = name, w.setVar(name, value)
+			w.emit(e)
+		case strings.HasPrefix(s, "prompt:: "):
+			s = strings.TrimPrefix(s, "prompt:: ")
+			s = strings.TrimSuffix(s, "\n")
+
+			name, question, _ := strings.Cut(s, " ")
+			if !varNameRegexp.MatchString(name) {
+				slog.Error("invalid variable name", "task", task.Id, "name", name)
+				w.Unlock()
+				continue
 			}
-			slog.Debug("task ended", "task", task)
+			question = w.redact(question)
+			task.Prompt = &Prompt{Name: name, Question: question}
+			e := w.taskEvent(EventPrompt, group, task)
+			e.Name, e.Message = name, question
+			w.emit(e)
+		case strings.HasPrefix(s, "error:: "):
+			s = strings.TrimPrefix(s, "error:: ")
+			s = w.redact(strings.TrimSpace(s))
+
+			task.Error = s
+			group.Error = s
+			w.Status.Error = s
+			e := w.taskEvent(EventError, group, task)
+			e.Message = s
+			w.emit(e)
+		}
+		w.Unlock()
+
+		err = w.writeStatus()
+		if err != nil {
+			slog.Error("unable to write status", "error", err)
+		}
+	}
+}
 
-			_ = w.writeStatus()
-			_ = w.writeSockets()
+// outcome returns the outcome of a run that returned err. Caller must hold the
+// lock.
+func (w *Workflow) outcome(err error) Outcome {
+	switch {
+	case err == nil && len(w.Status.Warnings) > 0:
+		return OutcomeWarning
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, WorkflowErrorTimeout):
+		return OutcomeTimeout
+	case errors.Is(w.ctx.Err(), context.Canceled):
+		return OutcomeAborted
+	}
+	return OutcomeFailure
+}
+
+// contextError returns the reason ctx is done, with WorkflowErrorTimeout for
+// expired timeouts, or nil if it is not done.
+func contextError(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return WorkflowErrorTimeout
+	}
+	return err
+}
+
+// interrupted returns a non nil error if ctx is done, because the workflow was
+// aborted or a timeout expired, and records it in errorField as well as in the
+// workflow error.
+func (w *Workflow) interrupted(ctx context.Context, errorField *string) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("workflow timed out", "error", err)
+		err = WorkflowErrorTimeout
+	} else {
+		slog.Warn("workflow aborted", "error", err)
+	}
+
+	w.Lock()
+	*errorField = err.Error()
+	w.Status.Error = err.Error()
+	w.Unlock()
 
-			if task.Exits {
-				os.Exit(128)
+	return err
+}
+
+// vars returns a copy of the workflow variables, including the ones published
+// by tasks, and the ones describing the outcome of the workflow when running
+// hooks.
+func (w *Workflow) vars() map[string]string {
+	w.Lock()
+	defer w.Unlock()
+	result := maps.Clone(w.Status.Vars)
+	for _, extra := range []map[string]string{w.secrets, w.hookVars} {
+		if len(extra) > 0 {
+			if result == nil {
+				result = map[string]string{}
 			}
+			maps.Copy(result, extra)
 		}
-		group.Finished = true
-		slog.Debug("group ended", "task", group)
-
-		_ = w.writeStatus()
-		_ = w.writeSockets()
 	}
+	return result
+}
 
-	return nil
+// runningIds returns the ids of currently running tasks. Caller must hold the
+// lock.
+func (w *Workflow) runningIds() []string {
+	result := []string{}
+	for _, t := range w.running {
+		result = append(result, t.Id)
+	}
+	return result
 }
 
 // Reset is used to set the workflow to a clean state, and is only possible
 // if execution is finished. It can be used to run a workflow again without
 // having to create a new instance.
 func (w *Workflow) Reset() error {
+	// The definition is read first, the state is then checked and replaced
+	// at once, so that the workflow can't start in between
+	status, err := newStatus(w.workflowPath)
+
+	w.Lock()
+	defer w.Unlock()
+	if w.active {
+		return WorkflowErrorRunning
+	}
 	if !w.Status.Finished {
 		return WorkflowErrorNotFinished
 	}
-	err := w.initialize()
 	if err != nil {
 		return err
 	}
+	w.initialize(status)
+
+	// Connected clients start over with the new status
+	messages, err := w.replay(-1)
+	if err != nil {
+		return err
+	}
+	w.clients.broadcast(messages[0])
 	return nil
 }
 
+// Answer answers the prompt name of task in group, publishing value as
+// variable name and resuming the task. It returns [WorkflowErrorNoPrompt] if
+// the task is not waiting for this answer.
+func (w *Workflow) Answer(group, task, name, value string) error {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, t := range w.running {
+		if t.Id != task || t.Prompt == nil || t.Prompt.Name != name {
+			continue
+		}
+		for _, hook := range []string{"", "onSuccess", "onFailure", "finally"} {
+			for _, g := range w.groups(hook) {
+				if g.Id != group || !slices.Contains(g.Tasks, t) {
+					continue
+				}
+
+				t.Prompt = nil
+				e := w.taskEvent(EventAnswer, g, t)
+				e.Name, e.Value = name, w.setVar(name, value)
+				w.emit(e)
+
+				// The task may have been answered already, without reading
+				select {
+				case t.answers <- value:
+				default:
+				}
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w %s for task %s/%s", WorkflowErrorNoPrompt, name, group, task)
+}
+
 // Abort kills current tasks and stops workflow execution
 func (w *Workflow) Abort() {
-	if w.cancel == nil {
+	w.Lock()
+	cancel := w.cancel
+	w.Unlock()
+	if cancel == nil {
 		slog.Error("Trying to abort a workflow that is not running")
 		return
 	}
-	w.cancel()
-	err := w.currentTask.abort()
-	if err != nil {
-		slog.Error("unable to abort task", "error", err)
-	}
+	// Running tasks are killed when the context is done
+	cancel()
 }
 
 // Continue is used instead of [Start] when a status file already exists after
 // a previous unfinished run. If the statufile does not exists, it will
 // return a file not found error.
 func (w *Workflow) Continue() error {
+	return w.ContinueWithInputs(nil)
+}
+
+// ContinueWithInputs continues the workflow like [Continue], with inputs
+// giving the value of variables like [Workflow.StartWithInputs]. Required
+// secret inputs are not written to the status file, so they must be given
+// again.
+func (w *Workflow) ContinueWithInputs(inputs map[string]string) error {
 	_, err := os.Stat(w.statusPath)
 	if err != nil {
 		return err
 	}
-	err = w.Start()
+	err = w.StartWithInputs(inputs)
 	if err != nil {
 		return err
 	}
@@ -460,6 +1394,9 @@ func (w *Workflow) Continue() error {
 // Percent returns the completion percentage between 0 and 100 of the workflow
 func (w *Workflow) percent() float64 {
 	current, total := w.progress()
+	if total == 0 {
+		return 0
+	}
 	return float64(current) / float64(total) * 100
 }
 
@@ -474,6 +1411,13 @@ func (w *Workflow) progress() (float64, float64) {
 		current += c
 		total += p
 	}
+
+	// Hooks have their own progress, but don't count in the workflow one
+	for _, hooks := range [][]*Group{w.Status.OnSuccess, w.Status.OnFailure, w.Status.Finally} {
+		for _, group := range hooks {
+			group.progress()
+		}
+	}
 	return current, total
 }
 
@@ -494,15 +1438,3 @@ func (w *Workflow) writeStatus() error {
 	}
 	return nil
 }
-
-func (w *Workflow) writeSockets() error {
-	for i := range w.ws {
-		ws := w.ws[i]
-		err := wsjson.Write(context.Background(), ws, w.Status)
-		if err != nil {
-			slog.Error("unable to write status to websocket.", "error", err)
-			continue
-		}
-	}
-	return nil
-}
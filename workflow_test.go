@@ -2,10 +2,17 @@ package workflow
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -43,6 +50,37 @@ func make_socket(t *testing.T, wf *Workflow, fn http.Handler) (*websocket.Conn,
 	return conn, func() { start <- true }
 }
 
+// read_events reads events from conn until it is closed, and returns them.
+func read_events(conn *websocket.Conn) []Event {
+	result := []Event{}
+	for {
+		var e Event
+		err := wsjson.Read(context.Background(), conn, &e)
+		if err != nil {
+			if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+				slog.Error("error while reading", "error", err)
+			}
+			return result
+		}
+		result = append(result, e)
+	}
+}
+
+// messages returns the last message of the snapshot in events, if any, and
+// the messages of output events, one per line.
+func messages(events []Event) string {
+	result := ""
+	for _, e := range events {
+		switch {
+		case e.Type == EventSnapshot && e.Status.LastMessage != "":
+			result += e.Status.LastMessage + "\n"
+		case e.Type == EventOutput:
+			result += e.Message + "\n"
+		}
+	}
+	return result
+}
+
 // Test just the workflow proper execution
 func TestWorkflow(t *testing.T) {
 	wf, _, err := New("test_data/test.yaml", "test_data/status.json")
@@ -71,26 +109,9 @@ func TestWorkflowWS(t *testing.T) {
 
 	start()
 
-	got := ""
-	var previous string
-	for {
-		var message Status
-
-		err := wsjson.Read(context.Background(), conn, &message)
-		if err != nil {
-			if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
-				break
-			}
-			slog.Error("error while reading", "error", err)
-			break
-		}
-		if message.LastMessage != "" && message.LastMessage != previous {
-			got += message.LastMessage + "\n"
-		}
-		previous = message.LastMessage
-	}
+	got := messages(read_events(conn))
 
-	want := "Some Data\nvar1\nvar2\ntask2 finished\nvar1\nvar2\nvar1\nvar2\n"
+	want := "Some Data\nvar1\nvar2\ntask2 finished\nvar1\nvar2\n"
 
 	if got != want {
 		t.Fatalf("want %q, got %q", want, got)
@@ -132,20 +153,10 @@ func TestWorkflowLateWS(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Log messages
-	var message Status
-
-	var got, previous string
-
-	for wsjson.Read(context.Background(), conn, &message) == nil {
-		if message.LastMessage != previous {
-			got += message.LastMessage + "\n"
-		}
-		previous = message.LastMessage
-	}
+	// The snapshot has the last message, followed by the next ones
+	got := messages(read_events(conn))
 
-	// Reading is done, test output
-	want := "var1\nvar2\ntask2 finished\nvar1\nvar2\nvar1\nvar2\n"
+	want := "var1\nvar2\ntask2 finished\nvar1\nvar2\n"
 
 	if got != want {
 		t.Fatalf("want %q, got %q", want, got)
@@ -166,7 +177,7 @@ func TestWorkflowEarlyTerminationWS(t *testing.T) {
 
 	conn, start := make_socket(t, wf, fn)
 
-	var message Status
+	var message Event
 
 	got := ""
 
@@ -175,9 +186,9 @@ func TestWorkflowEarlyTerminationWS(t *testing.T) {
 	for {
 		err = wsjson.Read(context.Background(), conn, &message)
 		if err != nil {
-			slog.Error("error while reading", "error", err)
+			t.Fatal(err)
 		}
-		if message.LastMessage != "" {
+		if message.Type == EventOutput {
 			break
 		}
 	}
@@ -189,7 +200,7 @@ func TestWorkflowEarlyTerminationWS(t *testing.T) {
 
 	want := "Some Data\n"
 
-	got += message.LastMessage + "\n"
+	got += message.Message + "\n"
 
 	wf.Abort()
 
@@ -197,9 +208,135 @@ func TestWorkflowEarlyTerminationWS(t *testing.T) {
 		t.Fatalf("want %q, got %q", want, got)
 	}
 
-	// Check the disconnected socket has been cleaned up
-	if len(wf.ws) != 0 {
-		t.Fatalf("websocket not closed")
+	// Check the disconnected socket has been cleaned up, and wait for the
+	// aborted run to finish
+	deadline := time.Now().Add(5 * time.Second)
+	for wf.clients.len() != 0 || wf.isRunning() {
+		if time.Now().After(deadline) {
+			t.Fatalf("websocket not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Test that tasks set for skipping are effectively skipped
+func TestTaskSkip(t *testing.T) {
+	wf, _, err := New("test_data/test-skip-task.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := wf.Status.Groups[0].Tasks
+	for i, skip := range []bool{true, true, false, false} {
+		if tasks[i].Skip != skip || tasks[i].Started == skip {
+			t.Fatalf("unexpected task status %+v", tasks[i])
+		}
+	}
+
+	if wf.Status.LastMessage != "task4" {
+		t.Fatalf("want %q, got %q", "task4", wf.Status.LastMessage)
+	}
+
+	if wf.Status.Percent != 100 {
+		t.Fatalf("want 100, got %d", wf.Status.Percent)
+	}
+}
+
+// Test that when expressions skip groups and tasks
+func TestWhen(t *testing.T) {
+	wf, _, err := New("test_data/test-when.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := wf.Status.Groups
+	if groups[0].Tasks[1].Skip || !groups[0].Tasks[2].Skip {
+		t.Fatalf("unexpected task skip values")
+	}
+	if groups[1].Skip || !groups[2].Skip {
+		t.Fatalf("unexpected group skip values")
+	}
+}
+
+// Test that invalid when expressions are reported at load time
+func TestWhenInvalid(t *testing.T) {
+	_, err := newGroup(&GroupDefinition{
+		Id:   "group1",
+		When: `OS == `,
+		Tasks: []*TaskDefinition{
+			{Id: "task1", Cmd: "true"},
+		},
+	})
+	if !errors.Is(err, WorkflowErrorInvalidExpression) {
+		t.Fatalf("want %v, got %v", WorkflowErrorInvalidExpression, err)
+	}
+}
+
+// Test that variables published by tasks are available to subsequent tasks
+func TestSetVariables(t *testing.T) {
+	statusPath := path.Join(t.TempDir(), "status.json")
+	wf, _, err := New("test_data/test-set.yaml", statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"VERSION": "1.2.3", "RELEASE": "1.2.3 stable"}
+	if !maps.Equal(wf.Status.Vars, want) {
+		t.Fatalf("want %v, got %v", want, wf.Status.Vars)
+	}
+
+	if got := wf.Status.Groups[0].Tasks[1].LastMessage; got != "version 1.2.3 (1.2.3 stable)" {
+		t.Fatalf("unexpected message %q", got)
+	}
+
+	if !wf.Status.Groups[1].Skip {
+		t.Fatalf("group2 should be skipped")
+	}
+}
+
+// Test that on_failure and finally hooks run after a failure
+func TestHooks(t *testing.T) {
+	wf, _, err := New("test_data/test-hooks.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if err == nil || err.Error() != "exit status 2" {
+		t.Fatalf("want %q, got %v", "exit status 2", err)
+	}
+
+	if wf.Status.OnSuccess[0].Started {
+		t.Fatalf("on_success hook should not run")
+	}
+
+	want := "failure: exit status 2"
+	if got := wf.Status.Vars["FAILURE"]; got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	cleanup := wf.Status.Finally[0]
+	if !cleanup.Finished || cleanup.LastMessage != "cleanup" || cleanup.Percent != 100 {
+		t.Fatalf("finally hook should run %+v", cleanup)
+	}
+
+	if wf.Status.Outcome != OutcomeFailure {
+		t.Fatalf("want %q, got %q", OutcomeFailure, wf.Status.Outcome)
 	}
 }
 
@@ -216,21 +353,11 @@ func TestGroupSkip(t *testing.T) {
 
 	conn, start := make_socket(t, wf, fn)
 
-	var status Status
-
 	start()
-	for {
-		err := wsjson.Read(context.Background(), conn, &status)
-		if err != nil {
-			if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
-				break
-			}
-			slog.Error("error while reading", "error", err)
-			break
-		}
-		if status.LastMessage != "group2" {
-			t.Fatalf("group1 not skipped")
-		}
+
+	got := messages(read_events(conn))
+	if got != "group2\n" {
+		t.Fatalf("group1 not skipped, got %q", got)
 	}
 }
 
@@ -262,13 +389,14 @@ func TestOutputAndProgress(t *testing.T) {
 		{Percent: 80, Message: "task2"},
 		{Percent: 100, Message: "task2"},
 	}
+	message := ""
 	for i := range statuses {
 		status := statuses[i]
 		expectedPercent := status.Percent
 		expectedMessage := status.Message
 		for {
-			var s Status
-			err := wsjson.Read(context.Background(), conn, &s)
+			var e Event
+			err := wsjson.Read(context.Background(), conn, &e)
 			if err != nil {
 				if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
 					goto end
@@ -276,8 +404,11 @@ func TestOutputAndProgress(t *testing.T) {
 				slog.Error("error while reading", "error", err)
 				break
 			}
-			matchPercent := expectedPercent == s.Percent
-			matchMessage := expectedMessage == s.LastMessage
+			if e.Type == EventOutput {
+				message = e.Message
+			}
+			matchPercent := expectedPercent == e.Percent
+			matchMessage := expectedMessage == message
 			if matchPercent && matchMessage {
 				status.Done = true
 				goto next
@@ -293,6 +424,248 @@ end:
 	}
 }
 
+// Test that tasks of a parallel group run concurrently
+func TestParallelGroup(t *testing.T) {
+	wf, _, err := New("test_data/test-parallel.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Three tasks sleeping one second with a concurrency of two
+	elapsed := time.Since(start)
+	if elapsed < 2*time.Second || elapsed >= 3*time.Second {
+		t.Fatalf("unexpected parallel execution time %s", elapsed)
+	}
+
+	for _, task := range wf.Status.Groups[0].Tasks {
+		if !task.Finished {
+			t.Fatalf("task %s not finished", task.Id)
+		}
+		if task.LastMessage != task.Id {
+			t.Fatalf("want %q, got %q", task.Id, task.LastMessage)
+		}
+	}
+
+	if len(wf.Status.CurrentTasks) != 0 {
+		t.Fatalf("tasks still running %v", wf.Status.CurrentTasks)
+	}
+
+	if wf.Status.Outcome != OutcomeSuccess {
+		t.Fatalf("want %q, got %q", OutcomeSuccess, wf.Status.Outcome)
+	}
+}
+
+// Test that exits tasks are rejected in parallel groups
+func TestParallelGroupExits(t *testing.T) {
+	_, err := newGroup(&GroupDefinition{
+		Id:       "group1",
+		Parallel: true,
+		Tasks: []*TaskDefinition{
+			{Id: "task1", Cmd: "true", Exits: true},
+		},
+	})
+	if err != WorkflowErrorParallelExits {
+		t.Fatalf("want %v, got %v", WorkflowErrorParallelExits, err)
+	}
+}
+
+// Test that depends_on is honored and failures block downstream nodes
+func TestDependencies(t *testing.T) {
+	wf, _, err := New("test_data/test-dag.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if err == nil {
+		t.Fatal("expected task2 to fail")
+	}
+
+	if wf.Status.Outcome != OutcomeFailure {
+		t.Fatalf("want %q, got %q", OutcomeFailure, wf.Status.Outcome)
+	}
+
+	group1 := wf.Status.Groups[0]
+	if !group1.Tasks[0].Finished || !group1.Tasks[2].Finished {
+		t.Fatalf("task1 and task3 should have finished")
+	}
+	if group1.Tasks[1].Error == "" {
+		t.Fatalf("task2 should have failed")
+	}
+	if !group1.Tasks[3].Blocked || group1.Tasks[3].Started {
+		t.Fatalf("task4 should be blocked")
+	}
+
+	group2 := wf.Status.Groups[1]
+	if !group2.Finished {
+		t.Fatalf("group2 should have finished")
+	}
+
+	group3 := wf.Status.Groups[2]
+	if !group3.Blocked || group3.Started {
+		t.Fatalf("group3 should be blocked")
+	}
+}
+
+// Test that failing tasks are retried with backoff
+func TestRetries(t *testing.T) {
+	// The task counts its attempts in a file of this directory
+	t.Setenv("RETRY_DIR", t.TempDir())
+
+	wf, _, err := New("test_data/test-retry.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 100ms before the second attempt, 200ms before the third
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("retry delays not honored, took %s", elapsed)
+	}
+
+	task := wf.Status.Groups[0].Tasks[0]
+	if task.Attempt != 3 || len(task.Attempts) != 3 {
+		t.Fatalf("want 3 attempts, got %d %+v", task.Attempt, task.Attempts)
+	}
+	for i, want := range []int{4, 4, 0} {
+		if task.Attempts[i].Number != i+1 || task.Attempts[i].ExitCode != want {
+			t.Fatalf("unexpected attempt %+v", task.Attempts[i])
+		}
+	}
+	if !task.Finished || task.Error != "" || task.LastMessage != "attempt 3" {
+		t.Fatalf("unexpected task status %+v", task)
+	}
+}
+
+// Test that tasks and workflows running too long are killed
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"task", "test_data/test-timeout-task.yaml"},
+		{"workflow", "test_data/test-timeout.yaml"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wf, _, err := New(test.path, path.Join(t.TempDir(), "status.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			err = wf.Start()
+			if !errors.Is(err, WorkflowErrorTimeout) {
+				t.Fatalf("want %v, got %v", WorkflowErrorTimeout, err)
+			}
+			if wf.Status.Outcome != OutcomeTimeout {
+				t.Fatalf("want %q, got %q", OutcomeTimeout, wf.Status.Outcome)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("task not killed, took %s", elapsed)
+			}
+
+			group := wf.Status.Groups[0]
+			if !wf.Status.TimedOut || !group.TimedOut || !group.Tasks[0].TimedOut {
+				t.Fatalf("unexpected timeout flags %+v", wf.Status)
+			}
+			if group.Tasks[0].LastMessage != "started" || group.Tasks[0].Finished {
+				t.Fatalf("unexpected task status %+v", group.Tasks[0])
+			}
+
+			// Timeouts are serialized in their string form, like in definitions
+			b, err := json.Marshal(wf.Status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var status struct {
+				Timeout json.RawMessage
+				Groups  []struct {
+					Tasks []struct{ Timeout json.RawMessage }
+				}
+			}
+			if err := json.Unmarshal(b, &status); err != nil {
+				t.Fatal(err)
+			}
+			timeout := status.Timeout
+			if timeout == nil {
+				timeout = status.Groups[0].Tasks[0].Timeout
+			}
+			if string(timeout) != `"500ms"` {
+				t.Fatalf("want \"500ms\", got %s", timeout)
+			}
+		})
+	}
+}
+
+// Test that skip_cmd is killed when the workflow times out
+func TestTimeoutSkip(t *testing.T) {
+	wf, _, err := New("test_data/test-timeout-skip.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = wf.Start()
+	if !errors.Is(err, WorkflowErrorTimeout) {
+		t.Fatalf("want %v, got %v", WorkflowErrorTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("skip_cmd not killed, took %s", elapsed)
+	}
+
+	task := wf.Status.Groups[0].Tasks[0]
+	if !wf.Status.TimedOut || !task.TimedOut || task.Skip || task.Started {
+		t.Fatalf("unexpected task status %+v", task)
+	}
+}
+
+// Test that failures of tasks allowed to fail don't stop the workflow
+func TestAllowFailure(t *testing.T) {
+	wf, _, err := New("test_data/test-allow-failure.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wf.Status.Outcome != OutcomeWarning {
+		t.Fatalf("want %q, got %q", OutcomeWarning, wf.Status.Outcome)
+	}
+
+	want := []string{"group1/task1: exit status 3", "group2/task1: cleanup failed"}
+	if !slices.Equal(wf.Status.Warnings, want) {
+		t.Fatalf("want %q, got %q", want, wf.Status.Warnings)
+	}
+
+	for _, group := range wf.Status.Groups {
+		if !group.Warning || !group.Finished {
+			t.Fatalf("unexpected group status %+v", group)
+		}
+		if !group.Tasks[0].Warning || group.Tasks[0].ExitCode == 0 {
+			t.Fatalf("unexpected task status %+v", group.Tasks[0])
+		}
+		if !group.Tasks[1].Finished || group.Tasks[1].Warning {
+			t.Fatalf("unexpected task status %+v", group.Tasks[1])
+		}
+	}
+}
+
 // func TestDoc(t *testing.T) {
 // 	t.Cleanup(func() {
 // 		os.Remove("test_data/status.json")
@@ -320,3 +693,230 @@ end:
 // 		slog.Info("status", "status", status)
 // 	}
 // }
+
+// Test exec tasks and custom executors
+func TestExecutors(t *testing.T) {
+	wf, _, err := New("test_data/test-executors.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf.Status.Vars = map[string]string{"NAME": "world"}
+
+	wf.RegisterExecutor("download", ExecutorFunc(func(ctx context.Context, e *Execution) error {
+		url, _ := e.Task.With["url"].(string)
+		e.Output("downloading " + url)
+		e.Progress(0.5)
+		e.Set("DOWNLOADED", url)
+		return nil
+	}))
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := wf.Status.Groups[0].Tasks
+	want := []string{"hello world", "downloading https://example.com/file", "https://example.com/file"}
+	for i := range tasks {
+		if !tasks[i].Finished || tasks[i].LastMessage != want[i] {
+			t.Fatalf("task %s: want %q, got %+v", tasks[i].Id, want[i], tasks[i])
+		}
+	}
+}
+
+// Test that tasks of an unknown type fail before running anything
+func TestExecutorUnknown(t *testing.T) {
+	wf, _, err := New("test_data/test-executor-unknown.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if !errors.Is(err, WorkflowErrorUnknownTaskType) {
+		t.Fatalf("want %v, got %v", WorkflowErrorUnknownTaskType, err)
+	}
+	if wf.Status.Groups[0].Started {
+		t.Fatalf("group should not start")
+	}
+}
+
+// Test that programs that can't be started report why
+func TestExecutorStart(t *testing.T) {
+	wf, _, err := New("test_data/test-executor-start.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want %v, got %v", fs.ErrNotExist, err)
+	}
+	task := wf.Status.Groups[0].Tasks[0]
+	if !strings.Contains(task.Error, "/nonexistent/prog") || task.Finished {
+		t.Fatalf("unexpected task status %+v", task)
+	}
+}
+
+// Test starting a workflow with inputs
+func TestStartWithInputs(t *testing.T) {
+	wf, _, err := New("test_data/test-inputs.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		inputs map[string]string
+		want   string
+	}{
+		{nil, "missing required ENVIRONMENT"},
+		{map[string]string{"ENVIRONMENT": "test"}, "ENVIRONMENT must be one of staging, production"},
+		{map[string]string{"ENVIRONMENT": "staging", "REPLICAS": "many"}, "REPLICAS must be an integer"},
+		{map[string]string{"ENVIRONMENT": "staging", "VERSION": "1.0"}, "unknown variable VERSION"},
+	}
+	for _, test := range tests {
+		err = wf.StartWithInputs(test.inputs)
+		if !errors.Is(err, WorkflowErrorInvalidInput) || !strings.Contains(err.Error(), test.want) {
+			t.Fatalf("%v: want %q, got %v", test.inputs, test.want, err)
+		}
+	}
+	if wf.Status.Started {
+		t.Fatalf("workflow should not start with invalid inputs")
+	}
+
+	err = wf.StartWithInputs(map[string]string{"ENVIRONMENT": "production", "DEBUG": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"ENVIRONMENT": "production", "REPLICAS": "2", "DEBUG": "true", "REGION": "eu-west-1"}
+	if !maps.Equal(wf.Status.Vars, want) {
+		t.Fatalf("want %v, got %v", want, wf.Status.Vars)
+	}
+	tasks := wf.Status.Groups[0].Tasks
+	if tasks[0].LastMessage != "production 2 eu-west-1" || tasks[1].Skip {
+		t.Fatalf("unexpected tasks %+v %+v", tasks[0], tasks[1])
+	}
+}
+
+// Test that variables are initialized after the ones they reference
+func TestVarsOrder(t *testing.T) {
+	wf, _, err := New("test_data/test-vars-order.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"OS": "linux", "VERSION": "linux-1.0", "RELEASE": "linux-1.0 stable"}
+	if !maps.Equal(wf.Status.Vars, want) {
+		t.Fatalf("want %v, got %v", want, wf.Status.Vars)
+	}
+	if got := wf.Status.Groups[0].Tasks[0].LastMessage; got != "linux-1.0 stable" {
+		t.Fatalf("unexpected message %q", got)
+	}
+}
+
+// Test that errors name the failing variable, with its standard error
+func TestVarsError(t *testing.T) {
+	wf, _, err := New("test_data/test-vars-error.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	var varErr *VarError
+	if !errors.As(err, &varErr) {
+		t.Fatalf("want a VarError, got %v", err)
+	}
+	if varErr.Name != "BROKEN" || varErr.Stderr != "no package for linux" {
+		t.Fatalf("unexpected error %+v", varErr)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("want exit code 3, got %v", err)
+	}
+}
+
+// Test that variable commands are killed when the workflow times out
+func TestVarsTimeout(t *testing.T) {
+	wf, _, err := New("test_data/test-vars-timeout.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = wf.Start()
+	if !errors.Is(err, WorkflowErrorTimeout) {
+		t.Fatalf("want %v, got %v", WorkflowErrorTimeout, err)
+	}
+	var varErr *VarError
+	if !errors.As(err, &varErr) || varErr.Name != "SLOW" {
+		t.Fatalf("want a VarError for SLOW, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("variable command not killed, took %s", elapsed)
+	}
+}
+
+// Test the environment of tasks, skip_cmd and variables
+func TestEnv(t *testing.T) {
+	t.Setenv("WORKFLOW_TEST_HOME", "/home/test")
+	t.Setenv("WORKFLOW_TEST_SHELL", "zsh")
+
+	wf, _, err := New("test_data/test-env.yaml", path.Join(t.TempDir(), "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		task string
+		want string
+	}{
+		{"inherited", "/home/test  group eu-west-1 /home/test-workflow"},
+		{"all", "/home/test zsh task eu-west-1"},
+		{"none", "unset group"},
+	}
+	for i, test := range tests {
+		task := wf.Status.Groups[0].Tasks[i]
+		if task.Id != test.task || task.Skip || task.LastMessage != test.want {
+			t.Errorf("%s: want %q, got %+v", test.task, test.want, task)
+		}
+	}
+}
+
+// Test writing the output of tasks elsewhere than the standard output
+func TestSetOutput(t *testing.T) {
+	dir := t.TempDir()
+	wf, _, err := New("test_data/test-output.yaml", path.Join(dir, "status.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(path.Join(dir, "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	wf.SetOutput(f)
+
+	err = wf.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "output:: task1\n") || !strings.Contains(string(b), "output:: task2\n") {
+		t.Fatalf("unexpected output %q", b)
+	}
+}